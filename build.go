@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/honr/vulcan/static"
+)
+
+// runBuild implements "build SRC DST": it writes every file under SRC,
+// transformed, to the same relative path under DST.
+func runBuild(args []string, stderr io.Writer) int {
+	if len(args) != 2 {
+		fmt.Fprintln(stderr, "usage: build SRC DST")
+		return 2
+	}
+	failed := 0
+	err := build(args[0], args[1], func(err error) {
+		fmt.Fprintln(stderr, err)
+		failed++
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// build transforms each file under src that ffe would serve and writes it
+// under dst, skipping dotfiles, like .git, as ffe does.  Errors about
+// individual files are passed to report, which lets the build carry on;
+// errors walking src are returned.
+func build(src, dst string, report func(error)) error {
+	return static.WalkFiles(src, static.StaticOptions{}, func(path, p string) error {
+		if err := buildFile(path, filepath.Join(dst, filepath.FromSlash(p))); err != nil {
+			report(fmt.Errorf("%s: %v", path, err))
+		}
+		return nil
+	})
+}
+
+// buildFile writes the resources transformed from the file in to out, or to
+// out with its extension replaced by their suffix.
+func buildFile(in, out string) error {
+	resources, err := static.ResourcesFromFile(in)
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(out)
+	for _, resource := range resources {
+		p := out
+		if resource.Suffix != "" {
+			p = strings.TrimSuffix(out, ext) + resource.Suffix
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(p, resource.Content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
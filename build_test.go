@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuild(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	for name, content := range map[string]string{
+		"index.htl":       "(p hi)",
+		"css/site.css":    "p {}",
+		"blog/first.htl":  "(h1 \"first\")",
+		".git/config":     "[core]",
+		"blog/.draft.htl": "(p draft)",
+	} {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"build", src, dst}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("build = %d, stderr %q", code, stderr.String())
+	}
+	for name, want := range map[string]string{
+		"index.html":      "<p>hi</p>",
+		"css/site.css":    "p {}",
+		"blog/first.html": "<h1>first</h1>",
+	} {
+		got, err := ioutil.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"index.htl", ".git", "blog/.draft.html", "blog/.draft.htl"} {
+		if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s is in the output, want it left out as ffe does not serve it", name)
+		}
+	}
+}
+
+func TestBuildReportsFilenames(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	bad := filepath.Join(src, "bad.htl")
+	if err := ioutil.WriteFile(bad, []byte("(p"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"build", src, dst}, nil, &stdout, &stderr); code != 1 {
+		t.Errorf("build = %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), bad) {
+		t.Errorf("stderr %q does not name %s", stderr.String(), bad)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/honr/vulcan/static"
+)
+
+// adminPrefix starts the paths of the admin endpoints, which shadow any file
+// served under it.
+const adminPrefix = "/__ffe/"
+
+// admin serves the Mux returned by build, and the admin endpoints.  POST
+// /__ffe/reload builds the Mux again, rereading the directories, and serves
+// the new one from then on.  The whole Mux is swapped, rather than only the
+// resources it holds, so its index, listing, fallback and sitemap paths are
+// rebuilt along with them.  GET /__ffe/stats returns, as JSON, the number of
+// requests for each path and the memory in use.  The endpoints answer only to
+// clients on the loopback interface.
+type admin struct {
+	build func() (*static.Mux, error)
+	mux   atomic.Value // *static.Mux
+
+	mu       sync.Mutex
+	requests map[string]int64 // Requests served, by path.
+	reloads  int
+}
+
+// newAdmin returns an admin for the Muxes built by build, which it builds
+// once.  The Muxes should report to its count method as their Metrics.
+func newAdmin(build func() (*static.Mux, error)) (*admin, error) {
+	a := &admin{build: build, requests: map[string]int64{}}
+	m, err := build()
+	if err != nil {
+		return nil, err
+	}
+	a.mux.Store(m)
+	return a, nil
+}
+
+// current returns the Mux being served.
+func (a *admin) current() *static.Mux {
+	return a.mux.Load().(*static.Mux)
+}
+
+// count records a request served by the Mux.
+func (a *admin) count(rm static.RequestMetrics) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requests[rm.Path]++
+}
+
+func (a *admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, adminPrefix) {
+		a.current().ServeHTTP(w, r)
+		return
+	}
+	if !isLoopback(r.RemoteAddr) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	switch strings.TrimPrefix(r.URL.Path, adminPrefix) {
+	case "reload":
+		a.serveReload(w, r)
+	case "stats":
+		a.serveStats(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (a *admin) serveReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	m, err := a.build()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.mux.Store(m)
+	a.mu.Lock()
+	a.reloads++
+	a.mu.Unlock()
+	writeJSON(w, map[string]int{"paths": len(m.Paths())})
+}
+
+// adminStats is the reply of /__ffe/stats.
+type adminStats struct {
+	Paths    int              `json:"paths"`    // Paths registered.
+	Reloads  int              `json:"reloads"`  // Reloads since starting.
+	Requests map[string]int64 `json:"requests"` // Requests served, by path.
+	Memory   struct {
+		Alloc       uint64 `json:"alloc"`        // Bytes of live heap objects.
+		Sys         uint64 `json:"sys"`          // Bytes obtained from the system.
+		HeapObjects uint64 `json:"heap_objects"` // Live heap objects.
+		NumGC       uint32 `json:"num_gc"`       // Garbage collections run.
+	} `json:"memory"`
+}
+
+func (a *admin) serveStats(w http.ResponseWriter, r *http.Request) {
+	var stats adminStats
+	stats.Paths = len(a.current().Paths())
+	a.mu.Lock()
+	stats.Reloads = a.reloads
+	stats.Requests = make(map[string]int64, len(a.requests))
+	for p, n := range a.requests {
+		stats.Requests[p] = n
+	}
+	a.mu.Unlock()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	stats.Memory.Alloc, stats.Memory.Sys = ms.Alloc, ms.Sys
+	stats.Memory.HeapObjects, stats.Memory.NumGC = ms.HeapObjects, ms.NumGC
+	writeJSON(w, stats)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// isLoopback reports whether addr, a host:port pair, is on the loopback
+// interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
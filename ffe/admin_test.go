@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/honr/vulcan/static"
+)
+
+func TestAdminReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("index.htl", "(p one)")
+	a, err := newAdmin(func() (*static.Mux, error) {
+		return static.NewMux([]string{dir}, static.StaticOptions{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, p, remote string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, p, nil)
+		r.RemoteAddr = remote
+		a.ServeHTTP(w, r)
+		return w
+	}
+
+	write("index.htl", "(p two)")
+	write("new.txt", "new")
+	if w := serve("GET", "/new.txt", "127.0.0.1:1234"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /new.txt before reloading = %d, want 404", w.Code)
+	}
+	if w := serve("GET", "/__ffe/reload", "127.0.0.1:1234"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /__ffe/reload = %d, want 405", w.Code)
+	}
+	if w := serve("POST", "/__ffe/reload", "192.0.2.1:1234"); w.Code != http.StatusForbidden {
+		t.Errorf("POST /__ffe/reload from afar = %d, want 403", w.Code)
+	}
+	if w := serve("GET", "/new.txt", "[::1]:1234"); w.Code != http.StatusNotFound {
+		t.Errorf("a refused reload took effect")
+	}
+	if w := serve("POST", "/__ffe/reload", "[::1]:1234"); w.Code != http.StatusOK {
+		t.Fatalf("POST /__ffe/reload = %d %q, want 200", w.Code, w.Body.String())
+	}
+	if got := serve("GET", "/new.txt", "192.0.2.1:1234").Body.String(); got != "new" {
+		t.Errorf("GET /new.txt after reloading = %q, want new", got)
+	}
+	if got := serve("GET", "/index.html", "192.0.2.1:1234").Body.String(); got != "<p>two</p>" {
+		t.Errorf("GET /index.htl after reloading = %q, want <p>two</p>", got)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "new.txt")); err != nil {
+		t.Fatal(err)
+	}
+	write("index.htl", "(p")
+	if w := serve("POST", "/__ffe/reload", "127.0.0.1:1234"); w.Code != http.StatusInternalServerError {
+		t.Errorf("POST /__ffe/reload of a broken file = %d, want 500", w.Code)
+	}
+	if got := serve("GET", "/new.txt", "127.0.0.1:1234").Body.String(); got != "new" {
+		t.Errorf("a failed reload replaced the served files")
+	}
+}
+
+func TestAdminStats(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var a *admin
+	opts := static.StaticOptions{Metrics: func(rm static.RequestMetrics) { a.count(rm) }}
+	a, err := newAdmin(func() (*static.Mux, error) { return static.NewMux([]string{dir}, opts) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/a.txt", "/a.txt", "/missing"} {
+		a.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/__ffe/stats", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	a.ServeHTTP(w, r)
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	var stats adminStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("stats are not valid JSON: %v\n%s", err, w.Body.String())
+	}
+	if stats.Paths != 1 || stats.Requests["/a.txt"] != 2 || stats.Requests["/missing"] != 1 {
+		t.Errorf("stats = %+v, want 1 path, and 2 requests for /a.txt and 1 for /missing", stats)
+	}
+	if _, has := stats.Requests["/__ffe/stats"]; has {
+		t.Errorf("stats count requests for the admin endpoints")
+	}
+	if stats.Memory.Alloc == 0 || stats.Memory.Sys == 0 {
+		t.Errorf("stats report no memory in use: %+v", stats.Memory)
+	}
+}
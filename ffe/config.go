@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the name of the environment variable setting a flag, so
+// FFE_ADDR sets --addr and FFE_DEV_MODE sets --dev-mode.
+const envPrefix = "FFE_"
+
+// dirsKey is the setting, in a config file or as FFE_DIRS, listing the
+// directories to serve, colon-separated, when none are given as arguments.
+const dirsKey = "dirs"
+
+// envName returns the environment variable setting the flag or key name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// readConfig reads a config file of key = value lines, where keys are flag
+// names, or dirs.  Blank lines and lines starting with # are skipped.
+func readConfig(r io.Reader, name string) (map[string]string, error) {
+	config := map[string]string{}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		eq := strings.IndexByte(text, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("%s:%d: want key = value, got %q", name, line, text)
+		}
+		config[strings.TrimSpace(text[:eq])] = strings.TrimSpace(text[eq+1:])
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return config, nil
+}
+
+// readConfigFile reads the config file at filename with readConfig.
+func readConfigFile(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readConfig(f, filename)
+}
+
+// configure sets each flag of fs not given on the command line from config,
+// or failing that from the environment, and returns the directories to serve
+// when args is empty.  Flags thus override the config file, which overrides
+// the environment.
+func configure(fs *flag.FlagSet, config map[string]string, getenv func(string) string) ([]string, error) {
+	for key := range config {
+		if key != dirsKey && fs.Lookup(key) == nil {
+			return nil, fmt.Errorf("config: unknown setting %q", key)
+		}
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	lookup := func(name string) (string, bool) {
+		if v, has := config[name]; has {
+			return v, true
+		}
+		v := getenv(envName(name))
+		return v, v != ""
+	}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+		if v, has := lookup(f.Name); has {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("config: %s: %v", f.Name, e)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	args := fs.Args()
+	if len(args) == 0 {
+		if v, has := lookup(dirsKey); has {
+			args = strings.Split(v, ":")
+		}
+	}
+	return args, nil
+}
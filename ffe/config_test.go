@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	in := "# ffe settings\naddr = :8000\n\ndev-mode=false\ndirs = a:b\n"
+	got, err := readConfig(strings.NewReader(in), "ffe.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"addr": ":8000", "dev-mode": "false", "dirs": "a:b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readConfig = %v, want %v", got, want)
+	}
+
+	_, err = readConfig(strings.NewReader("addr = :8000\nlisting\n"), "ffe.conf")
+	if err == nil || !strings.Contains(err.Error(), "ffe.conf:2:") {
+		t.Errorf("readConfig of a line without = returned %v, want an error at ffe.conf:2", err)
+	}
+}
+
+func TestConfigurePrecedence(t *testing.T) {
+	cases := []struct {
+		args   []string
+		config map[string]string
+		env    map[string]string
+		addr   string
+		dev    bool
+		dirs   []string
+	}{
+		{nil, nil, nil, "", true, nil},
+		{nil, nil, map[string]string{"FFE_ADDR": ":1", "FFE_DEV_MODE": "false", "FFE_DIRS": "x:y"},
+			":1", false, []string{"x", "y"}},
+		{nil, map[string]string{"addr": ":2", "dirs": "z"},
+			map[string]string{"FFE_ADDR": ":1", "FFE_DEV_MODE": "false", "FFE_DIRS": "x:y"},
+			":2", false, []string{"z"}},
+		{[]string{"--addr=:3", "--dev-mode=true", "w"}, map[string]string{"addr": ":2", "dev-mode": "false", "dirs": "z"},
+			map[string]string{"FFE_ADDR": ":1"},
+			":3", true, []string{"w"}},
+		{[]string{"--dev-mode=false"}, map[string]string{"dev-mode": "true"}, nil,
+			"", false, nil},
+	}
+	for _, c := range cases {
+		fs := flag.NewFlagSet("ffe", flag.ContinueOnError)
+		addr := fs.String("addr", "", "")
+		dev := fs.Bool("dev-mode", true, "")
+		if err := fs.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+		dirs, err := configure(fs, c.config, func(k string) string { return c.env[k] })
+		if err != nil {
+			t.Errorf("configure(%q, %v, %v): %v", c.args, c.config, c.env, err)
+			continue
+		}
+		if *addr != c.addr || *dev != c.dev || strings.Join(dirs, ":") != strings.Join(c.dirs, ":") {
+			t.Errorf("configure(%q, %v, %v): addr %q, dev-mode %v, dirs %q; want %q, %v, %q",
+				c.args, c.config, c.env, *addr, *dev, dirs, c.addr, c.dev, c.dirs)
+		}
+	}
+}
+
+func TestConfigureErrors(t *testing.T) {
+	cases := []struct {
+		config map[string]string
+		env    map[string]string
+		want   string
+	}{
+		{map[string]string{"adress": ":1"}, nil, `unknown setting "adress"`},
+		{map[string]string{"dev-mode": "maybe"}, nil, "dev-mode"},
+		{nil, map[string]string{"FFE_DEV_MODE": "maybe"}, "dev-mode"},
+	}
+	for _, c := range cases {
+		fs := flag.NewFlagSet("ffe", flag.ContinueOnError)
+		fs.String("addr", "", "")
+		fs.Bool("dev-mode", true, "")
+		_, err := configure(fs, c.config, func(k string) string { return c.env[k] })
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("configure(%v, %v) = %v, want an error mentioning %q", c.config, c.env, err, c.want)
+		}
+	}
+}
@@ -1,58 +1,407 @@
 // ffe, short of Femto Front-End is a minimalistic frontend for serving static
 // contents from given directories.  Examples of usage:
-//   1. Serve the current directory and anything under it.
+//   1. Serve the current directory and anything under it, to this machine
+//   only: an addr of just a port listens on 127.0.0.1.
 //   $ ffe --addr=:8000
 //   2. Serve files from ./tmp/hello-world, ./web/common, and under them.
 //   $ ffe --addr=localhost:8000 web/common tmp/hello-world
 //   3. Serve files in non-dev-mode (read each file only once, serve from
 //   memory).
 //   $ ffe --addr=:8011 --dev=false #
+//   4. Browse the files of directories lacking an index file.
+//   $ ffe --addr=:8000 --listing
+//   5. Print which file each path is served from, and exit.
+//   $ ffe --print-routes web/common tmp/hello-world
+//   6. Serve a single file at / (and at /report.html).
+//   $ ffe --addr=:8000 --file=report.htl
+//   7. Read settings from a file of key = value lines, like addr = :8000 or
+//   dirs = web/common:tmp/hello-world.  Flags override the file, which
+//   overrides environment variables such as FFE_ADDR or FFE_DEV_MODE.
+//   $ ffe --config=ffe.conf
+//   8. Reread the directories without restarting, and see what was served.
+//   $ ffe --addr=localhost:8000 --dev-mode=false --admin
+//   $ curl -X POST localhost:8000/__ffe/reload
+//   $ curl localhost:8000/__ffe/stats
+//   9. Listen on a unix domain socket, as behind nginx, removing it on exit.
+//   $ ffe --addr=unix:/tmp/ffe.sock
+//   10. Send the headers that WebAssembly threads need with .wasm files.
+//   $ ffe --addr=:8000 --header='*.wasm=Cross-Origin-Embedder-Policy: require-corp' \
+//         --header='*.wasm=Cross-Origin-Opener-Policy: same-origin'
+//   11. Serve several sites by the Host of each request, and unknown hosts as
+//   foo.test.
+//   $ ffe --addr=:80 --vhost=foo.test=./foo --vhost=bar.test=./bar:./common \
+//         --default-host=foo.test
+//   12. Show htl files as they are, rather than rendered, to debug them.
+//   $ ffe --addr=:8000 --render=false
+//   13. Serve to the network, on all interfaces.
+//   $ ffe --addr=:8000 --host=0.0.0.0
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/honr/vulcan/static"
 )
 
 var (
-	addr    = flag.String("addr", "", "addr is the port and maybe hostname to listen to.  E.g., :8000 or localhost:8000")
-	devMode = flag.Bool("dev-mode", true, "Whether run in dev mode, where *registered* resources will be reread on each refresh.  If you add a new resource file, you need to restart the server for it to take effect.")
-	index   = flag.String("index", "/index.htl", "Default file, for instance /index.html")
+	addr     = flag.String("addr", "", "addr is the port and maybe hostname to listen to.  E.g., :8000 or localhost:8000, or unix: and the path of a unix domain socket, e.g. unix:/tmp/ffe.sock.  With just a port, --host is listened on.")
+	host     = flag.String("host", "127.0.0.1", "Host to listen on when --addr gives just a port, as :8000 does.  The default serves this machine only; 0.0.0.0 serves all interfaces, exposing the server to the network.")
+	devMode  = flag.Bool("dev-mode", true, "Whether run in dev mode, where *registered* resources will be reread on each refresh.  If you add a new resource file, you need to restart the server for it to take effect.")
+	index    = flag.String("index", "/index.html", "Default file, for instance /index.html")
+	debug    = flag.Bool("debug", false, "Whether to send the reason a resource failed to load, such as an htl parse error, to the client.")
+	listing  = flag.Bool("listing", false, "Whether to render an html index of directories lacking an index file.")
+	redirect = flag.Bool("redirect-canonical", true, "Whether to redirect requests to the canonical path of a resource, for instance /docs to /docs/.  Disable if a proxy in front rewrites paths the other way.")
+	frame    = flag.String("frame-options", "", "Value of the X-Frame-Options header, for instance DENY.  Omitted when empty.")
+	csp      = flag.String("csp", "", "Value of the Content-Security-Policy header.  Omitted when empty.")
+	file     = flag.String("file", "", "File to serve at /, and at its base name, instead of directories.")
+	routes   = flag.Bool("print-routes", false, "Whether to print each path and the file it is served from, sorted by path, and exit without listening.")
+	empty    = flag.Bool("warn-empty", true, "Whether to log a warning for htl files that yield an empty document, such as ones holding only comments.  Files of zero bytes are taken to be empty on purpose.")
+	ignore   = flag.String("ignore", "", "Comma-separated glob patterns of files and directories not to serve, for instance *.tmp,drafts/*.  Patterns with a slash match the path under a directory, others the base name.")
+	dotfiles = flag.Bool("dotfiles", false, "Whether to serve files whose names start with a dot, like .git/config.")
+	config   = flag.String("config", "", "File of key = value lines setting flags by name, and dirs, the colon-separated directories to serve.  Settings missing from it are read from environment variables like FFE_ADDR, and flags given on the command line override both.  Defaults to $FFE_CONFIG.")
+	sitemap  = flag.String("sitemap", "", "Base URL, such as https://example.com, under which to list the html pages in a generated /sitemap.xml, along with a /robots.txt pointing at it.  Files at those paths win.  Omitted when empty.")
+	stream   = flag.Bool("stream-html", false, "Whether to keep htl files parsed, rather than the html they yield, and serialize it on each request.  Saves memory on large pages outside dev mode.")
+	banner   = flag.Bool("dev-banner", false, "Whether to show a small \"dev build\" banner in a corner of the pages rendered from htl files in dev mode.")
+	srcHdr   = flag.Bool("source-header", false, "Whether to send, in dev mode, the absolute path of the file each response is read from as the X-Ffe-Source header.")
+	render   = flag.Bool("render", true, "Whether to render htl files to html.  When false, they are served as they are, as text/plain, to debug their source.")
+	fallback = flag.String("fallback", "", "Comma-separated prefix=path pairs, such as /app/=/app/index.html, serving the resource at path for paths under prefix that match no file, as single page apps need.")
+	nocase   = flag.Bool("case-insensitive", false, "Whether to match paths to files regardless of case, as case-insensitive filesystems do.  Files whose paths differ only by case are logged.")
+	adminAPI = flag.Bool("admin", false, "Whether to serve POST /__ffe/reload, rereading the directories, and GET /__ffe/stats, reporting requests by path and memory use as JSON, to clients on localhost only.")
+	gzipOn   = flag.Bool("gzip", false, "Whether to gzip text responses on the fly for clients accepting it.")
+	gzipLvl  = flag.Int("gzip-level", 0, "Gzip compression level, from 1 (fastest) to 9 (smallest).  0 means the default level.")
+	gzipMin  = flag.Int("gzip-min-size", 0, "Size in bytes below which responses are not gzipped.  0 means 1024.")
+	unsetEnv = flag.Bool("allow-undefined-env", false, "Whether {{env:NAME}} in htl files may name an unset environment variable, which is then taken as empty, rather than failing to load the file.")
+	defHost  = flag.String("default-host", "", "Host, given with --vhost, whose files are served for requests naming any other host.  When empty, those get 404 Not Found.")
+	keepOn   = flag.Bool("continue-on-error", false, "Whether to log the files that fail to load outside dev mode, such as htl files that do not parse, and serve the others, rather than refusing to start.")
+	maxBytes = flag.Int64("max-in-memory-bytes", 0, "Size, in bytes, of the largest file to serve.  Larger files, such as logs left in a served directory, are logged and skipped rather than read into memory.  Zero means no limit.")
+	strict   = flag.Bool("strict", false, "Whether to refuse to start when two directories hold a file at the same path, rather than serving the one in the latter directory.")
+
+	readTimeout  = flag.Duration("read-timeout", 10*time.Second, "Maximum time to read a request, headers and body.  Zero means no limit.")
+	writeTimeout = flag.Duration("write-timeout", 30*time.Second, "Maximum time from the end of reading a request's headers to the end of writing its response.  Zero means no limit.")
+	idleTimeout  = flag.Duration("idle-timeout", 2*time.Minute, "Maximum time to keep an idle keep-alive connection open.  Zero means no limit.")
+)
+
+var (
+	// vhosts holds the directories served for each host given with --vhost.
+	vhosts = hostDirs{}
+
+	// headers holds the headers given with --header, by path pattern.
+	headers = headerRules{}
 )
 
+func init() {
+	flag.Var(headers, "header", "pattern=Name: value rule, such as '*.wasm=Cross-Origin-Embedder-Policy: require-corp', sending the header with the files whose path matches the glob pattern: by base name, or as a whole if the pattern holds a slash, like /downloads/*.  May be repeated.")
+	flag.Var(vhosts, "vhost", "host=dir pair, with dirs colon-separated, such as foo.test=./foo:./common, serving the files under the dirs for requests whose Host is host.  May be repeated, or hold several comma-separated pairs.  Replaces the directories given as arguments.")
+}
+
+// hostDirs is a flag.Value collecting the directories served for each host,
+// from host=dir1:dir2 pairs.
+type hostDirs map[string][]string
+
+func (h hostDirs) String() string {
+	pairs := []string{}
+	for _, host := range sortedKeys(h) {
+		pairs = append(pairs, host+"="+strings.Join(h[host], ":"))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (h hostDirs) Set(v string) error {
+	for _, pair := range strings.Split(v, ",") {
+		eq := strings.IndexByte(pair, '=')
+		if eq <= 0 || eq == len(pair)-1 {
+			return fmt.Errorf("want host=dir[:dir...], got %q", pair)
+		}
+		h[pair[:eq]] = strings.Split(pair[eq+1:], ":")
+	}
+	return nil
+}
+
+// headerRules is a flag.Value collecting the headers sent for each path
+// pattern, from pattern=Name: value rules.
+type headerRules map[string]http.Header
+
+func (h headerRules) String() string {
+	rules := []string{}
+	for pattern, header := range h {
+		for k, vs := range header {
+			for _, v := range vs {
+				rules = append(rules, pattern+"="+k+": "+v)
+			}
+		}
+	}
+	sort.Strings(rules)
+	return strings.Join(rules, " ")
+}
+
+func (h headerRules) Set(v string) error {
+	eq := strings.IndexByte(v, '=')
+	colon := strings.IndexByte(v[eq+1:], ':')
+	if eq <= 0 || colon <= 0 {
+		return fmt.Errorf("want pattern=Name: value, got %q", v)
+	}
+	pattern, name, value := v[:eq], v[eq+1:eq+1+colon], strings.TrimSpace(v[eq+2+colon:])
+	if h[pattern] == nil {
+		h[pattern] = http.Header{}
+	}
+	h[pattern].Add(strings.TrimSpace(name), value)
+	return nil
+}
+
+// newServer returns a server for h on addr with the given timeouts, so that
+// slow or stalled clients cannot hold connections open indefinitely.
+func newServer(addr string, h http.Handler, read, write, idle time.Duration) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      h,
+		ReadTimeout:  read,
+		WriteTimeout: write,
+		IdleTimeout:  idle,
+	}
+}
+
+// unixPrefix starts an addr naming a unix domain socket rather than a TCP
+// address.
+const unixPrefix = "unix:"
+
+// listen listens on addr: a unix domain socket, created at the path after
+// unixPrefix, or a TCP address.  A socket file left behind by a server that
+// is gone is removed first; one a server still listens on, or a file that is
+// not a socket, is an error.  Closing the listener removes the socket file.
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, unixPrefix) {
+		return net.Listen("tcp", addr)
+	}
+	path := strings.TrimPrefix(addr, unixPrefix)
+	if path == "" {
+		return nil, errors.New("missing the socket path after " + unixPrefix)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// serve serves on l until interrupted or terminated, then shuts s down,
+// closing l, and waits for the requests in flight.
+func serve(s *http.Server, l net.Listener) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return static.ServeListener(ctx, s, l)
+}
+
+// printRoutes writes each path m serves and the file it is read from, one
+// tab-separated pair per line, sorted by path.
+func printRoutes(w io.Writer, m *static.Mux) {
+	for _, p := range m.Paths() {
+		fmt.Fprintf(w, "%s\t%s\n", p, m.Source(p))
+	}
+}
+
 func main() {
 	flag.Parse()
-	// staticDirs is the colon-separated list of directories containing static
-	// resources such as html, javascript, and css files.  Latter directories
-	// win when there are duplicate files.  When not specfied, current directory
-	// is read and served.
-	staticDirs := flag.Args()
+	settings := map[string]string{}
+	if *config == "" {
+		*config = os.Getenv(envName("config"))
+	}
+	if *config != "" {
+		var err error
+		if settings, err = readConfigFile(*config); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// staticDirs is the list of directories containing static resources such
+	// as html, javascript, and css files.  Latter directories win when there
+	// are duplicate files, unless --strict is set.  When not specfied, current
+	// directory is read and served.
+	staticDirs, err := configure(flag.CommandLine, settings, os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(vhosts) > 0 && (len(staticDirs) > 0 || *file != "" || *adminAPI || *sitemap != "" || *routes) {
+		log.Fatal("--vhost gives the directories of each host, and does not combine with directories, --file, --admin, --sitemap or --print-routes")
+	}
 	if len(staticDirs) == 0 {
 		staticDirs = []string{"."}
 	}
-	if *addr == "" {
+	if *addr == "" && !*routes {
 		log.Fatal("Must provide a port to listen to, such as :8000")
 	}
 
-	m, err := static.HandlersFromDirs(staticDirs, *devMode)
+	opts := static.StaticOptions{
+		Dev:     *devMode,
+		Debug:   *debug,
+		Index:   *index,
+		Listing: *listing,
+
+		RedirectToCanonical: *redirect,
+		StrictDuplicates:    *strict,
+		ContinueOnError:     *keepOn,
+		MaxInMemoryBytes:    *maxBytes,
+		CaseInsensitive:     *nocase,
+		StreamHTML:          *stream,
+		RawHTL:              !*render,
+		DevBanner:           *banner,
+		SourceHeader:        *srcHdr,
+		AllowUndefinedEnv:   *unsetEnv,
+		Gzip:                *gzipOn,
+		GzipLevel:           *gzipLvl,
+		GzipMinSize:         *gzipMin,
+		WarnEmpty:           *empty,
+		ServeDotfiles:       *dotfiles,
+
+		FrameOptions:          *frame,
+		ContentSecurityPolicy: *csp,
+	}
+	if *ignore != "" {
+		opts.Ignore = strings.Split(*ignore, ",")
+	}
+	if len(headers) > 0 {
+		opts.Headers = headers
+	}
+	if *fallback != "" {
+		opts.Fallbacks = map[string]string{}
+		for _, pair := range strings.Split(*fallback, ",") {
+			eq := strings.IndexByte(pair, '=')
+			if eq < 0 {
+				log.Fatalf("--fallback: want prefix=path, got %q", pair)
+			}
+			opts.Fallbacks[pair[:eq]] = pair[eq+1:]
+		}
+	}
+	if len(vhosts) > 0 {
+		h, err := static.NewHostMux(vhosts, *defHost, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, host := range sortedKeys(vhosts) {
+			fmt.Println("serving host", host, "from", strings.Join(vhosts[host], ", "))
+		}
+		listenAndServe(h)
+		return
+	}
+
+	var a *admin
+	if *adminAPI {
+		opts.Metrics = func(rm static.RequestMetrics) { a.count(rm) }
+	}
+	build := func() (*static.Mux, error) {
+		var m *static.Mux
+		var err error
+		if *file != "" {
+			m, err = static.NewFileMux(*file, opts)
+		} else {
+			m, err = static.NewMux(staticDirs, opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if *sitemap != "" {
+			if err := m.AddSitemap(*sitemap); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	m, err := build()
 	if err != nil {
 		log.Fatal(err)
 	}
-	for p, h := range m {
-		fmt.Println("registered path:", p)
-		http.HandleFunc(p, h)
-		if p == *index {
-			http.HandleFunc("/", h)
+	var h http.Handler = m
+	if *adminAPI {
+		if a, err = newAdmin(build); err != nil {
+			log.Fatal(err)
 		}
+		h = a
+	}
+	if *routes {
+		printRoutes(os.Stdout, m)
+		return
 	}
+	for _, p := range m.Paths() {
+		fmt.Println("registered path:", p, "from", m.Source(p))
+	}
+
+	listenAndServe(h)
+}
 
-	fmt.Println("listening on", *addr)
-	err = http.ListenAndServe(*addr, nil)
+// listenAndServe serves h on --addr, or --host if it gives just a port, until
+// interrupted, with the timeouts of the flags.
+func listenAndServe(h http.Handler) {
+	a := listenAddr(*addr, *host)
+	l, err := listen(a)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if reach := reachableFrom(a); reach != "" {
+		fmt.Printf("listening on %s (%s)\n", a, reach)
+	} else {
+		fmt.Println("listening on", a)
+	}
+	if err := serve(newServer(a, h, *readTimeout, *writeTimeout, *idleTimeout), l); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// listenAddr returns addr with host filled in if it gives just a port, as
+// :8000 does, so that the server is not exposed to the network by default.
+// Other addrs, including unix domain sockets, are returned as they are.
+func listenAddr(addr, host string) string {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return addr
+	}
+	h, port, err := net.SplitHostPort(addr)
+	if err != nil || h != "" {
+		return addr
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// reachableFrom says who can reach a server listening on the TCP address
+// addr, or returns "" for a unix domain socket.
+func reachableFrom(addr string) string {
+	h, _, err := net.SplitHostPort(addr)
+	if err != nil || strings.HasPrefix(addr, unixPrefix) {
+		return ""
+	}
+	ip := net.ParseIP(h)
+	switch {
+	case h == "localhost" || ip != nil && ip.IsLoopback():
+		return "this machine only"
+	case h == "" || ip != nil && ip.IsUnspecified():
+		return "all interfaces, exposed to the network"
+	}
+	return "the interface of " + h
+}
+
+// sortedKeys returns the hosts of h, sorted.
+func sortedKeys(h hostDirs) []string {
+	hosts := make([]string, 0, len(h))
+	for host := range h {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
 }
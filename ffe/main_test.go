@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honr/vulcan/static"
+)
+
+func TestNewServer(t *testing.T) {
+	h := http.NotFoundHandler()
+	s := newServer(":8000", h, time.Second, 2*time.Second, 3*time.Second)
+	if s.Addr != ":8000" || s.Handler == nil {
+		t.Errorf("newServer: Addr %q, Handler %v; want :8000 and the handler", s.Addr, s.Handler)
+	}
+	if s.ReadTimeout != time.Second || s.WriteTimeout != 2*time.Second || s.IdleTimeout != 3*time.Second {
+		t.Errorf("newServer: timeouts read %v, write %v, idle %v; want 1s, 2s, 3s",
+			s.ReadTimeout, s.WriteTimeout, s.IdleTimeout)
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	cases := []struct {
+		addr, host, want, reach string
+	}{
+		{":8000", "127.0.0.1", "127.0.0.1:8000", "this machine only"},
+		{":8000", "0.0.0.0", "0.0.0.0:8000", "all interfaces, exposed to the network"},
+		{":8000", "", ":8000", "all interfaces, exposed to the network"},
+		{":8000", "::1", "[::1]:8000", "this machine only"},
+		{"0.0.0.0:8000", "127.0.0.1", "0.0.0.0:8000", "all interfaces, exposed to the network"},
+		{"localhost:8000", "0.0.0.0", "localhost:8000", "this machine only"},
+		{"192.168.1.2:80", "127.0.0.1", "192.168.1.2:80", "the interface of 192.168.1.2"},
+		{"unix:/tmp/ffe.sock", "127.0.0.1", "unix:/tmp/ffe.sock", ""},
+	}
+	for _, c := range cases {
+		got := listenAddr(c.addr, c.host)
+		if got != c.want {
+			t.Errorf("listenAddr(%q, %q) = %q, want %q", c.addr, c.host, got, c.want)
+		}
+		if reach := reachableFrom(got); reach != c.reach {
+			t.Errorf("reachableFrom(%q) = %q, want %q", got, reach, c.reach)
+		}
+	}
+}
+
+func TestPrintRoutes(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		filepath.Join(first, "index.htl"):      "(p hi)",
+		filepath.Join(first, "css", "a.css"):   "p {}",
+		filepath.Join(second, "css", "a.css"):  "p { color: red }",
+		filepath.Join(second, "img", "b.png"):  "",
+		filepath.Join(second, "docs", "c.txt"): "c",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := static.NewMux([]string{first, second}, static.StaticOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	printRoutes(&b, m)
+	want := "/css/a.css\t" + filepath.Join(second, "css", "a.css") + "\n" +
+		"/docs/c.txt\t" + filepath.Join(second, "docs", "c.txt") + "\n" +
+		"/img/b.png\t" + filepath.Join(second, "img", "b.png") + "\n" +
+		"/index.html\t" + filepath.Join(first, "index.htl") + "\n"
+	if got := b.String(); got != want {
+		t.Errorf("printRoutes:\n  got: %q\n want: %q", got, want)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	// Socket paths are limited to about a hundred bytes, which t.TempDir may
+	// exceed.
+	dir, err := ioutil.TempDir("", "ffe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "ffe.sock")
+
+	// A socket left behind by a server that is gone.
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := listen("unix:" + sock)
+	if err != nil {
+		t.Fatalf("listen over a stale socket: %v", err)
+	}
+	if _, err := listen("unix:" + sock); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("listen on a socket in use: %v, want an error", err)
+	}
+	s := newServer("unix:"+sock, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}), time.Second, time.Second, time.Second)
+	go s.Serve(l)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := client.Get("http://ffe/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "hello" {
+		t.Errorf("GET over the socket = %q, %v; want hello", body, err)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(sock); !os.IsNotExist(err) {
+		t.Errorf("socket file left after shutdown: %v", err)
+	}
+}
+
+func TestListenUnixErrors(t *testing.T) {
+	notSocket := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(notSocket, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{"unix:", "unix:" + notSocket} {
+		if l, err := listen(addr); err == nil {
+			l.Close()
+			t.Errorf("listen(%q) succeeded, want an error", addr)
+		}
+	}
+	if _, err := os.Stat(notSocket); err != nil {
+		t.Errorf("listen removed a file that is not a socket: %v", err)
+	}
+}
+
+func TestHostDirs(t *testing.T) {
+	h := hostDirs{}
+	for _, v := range []string{"foo.test=./foo", "bar.test=./bar:./common,baz.test=baz"} {
+		if err := h.Set(v); err != nil {
+			t.Fatalf("Set(%q): %v", v, err)
+		}
+	}
+	if got, want := h.String(), "bar.test=./bar:./common,baz.test=baz,foo.test=./foo"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	for _, v := range []string{"foo.test", "=./foo", "foo.test="} {
+		if err := (hostDirs{}).Set(v); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", v)
+		}
+	}
+}
+
+func TestHeaderRules(t *testing.T) {
+	h := headerRules{}
+	for _, v := range []string{"*.wasm=Cross-Origin-Embedder-Policy: require-corp", "/dl/*=content-disposition:attachment"} {
+		if err := h.Set(v); err != nil {
+			t.Fatalf("Set(%q): %v", v, err)
+		}
+	}
+	if got, want := h["*.wasm"].Get("Cross-Origin-Embedder-Policy"), "require-corp"; got != want {
+		t.Errorf("*.wasm header = %q, want %q", got, want)
+	}
+	if got, want := h["/dl/*"].Get("Content-Disposition"), "attachment"; got != want {
+		t.Errorf("/dl/* header = %q, want %q", got, want)
+	}
+	for _, v := range []string{"*.wasm", "=X: y", "*.wasm=: y", "*.wasm=X"} {
+		if err := (headerRules{}).Set(v); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", v)
+		}
+	}
+}
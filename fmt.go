@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/honr/vulcan/htl"
+)
+
+// runFmt implements "fmt FILE...": it rewrites each htl file in canonical
+// form, leaving alone those already in it.  A file that fails to parse, or
+// holds comments formatting would drop, is reported and left alone, and the
+// rest are still formatted.
+func runFmt(args []string, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: fmt FILE...")
+		return 2
+	}
+	code := 0
+	for _, filename := range args {
+		if err := formatFile(filename); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", filename, err)
+			code = 1
+		}
+	}
+	return code
+}
+
+// formatFile rewrites filename in canonical form, if it is not already.
+func formatFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	formatted, err := htl.FormatSource(string(data))
+	if err != nil {
+		return err
+	}
+	if formatted == string(data) {
+		return nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, []byte(formatted), info.Mode().Perm())
+}
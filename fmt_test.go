@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFmt(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.htl")
+	bad := filepath.Join(dir, "bad.htl")
+	commented := filepath.Join(dir, "commented.htl")
+	if err := ioutil.WriteFile(commented, []byte("; header comment\n(p  a) ; trailing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(good, []byte("(p  a)  (br)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(bad, []byte("(p a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"fmt", good, bad, commented}, strings.NewReader(""), &stdout, &stderr); code != 1 {
+		t.Errorf("run(fmt) = %d, want 1", code)
+	}
+	for _, filename := range []string{bad, commented} {
+		if !strings.Contains(stderr.String(), filename) {
+			t.Errorf("stderr = %q, want it to name %s", stderr.String(), filename)
+		}
+	}
+	for filename, want := range map[string]string{
+		good:      "(p a)\n(br)\n",
+		bad:       "(p a",
+		commented: "; header comment\n(p  a) ; trailing\n",
+	} {
+		got, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", filename, got, want)
+		}
+	}
+
+	stderr.Reset()
+	if code := run([]string{"fmt", good}, strings.NewReader(""), &stdout, &stderr); code != 0 {
+		t.Errorf("run(fmt) again = %d, stderr %q; want 0", code, stderr.String())
+	}
+	if code := run([]string{"fmt"}, strings.NewReader(""), &stdout, &stderr); code != 2 {
+		t.Errorf("run(fmt) with no files = %d, want 2", code)
+	}
+}
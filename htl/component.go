@@ -0,0 +1,184 @@
+package htl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Forms expanded by ExpandComponents.
+const (
+	// (defcomponent name body...), at the top level of a document, defines
+	// the component name: each element named name, as in (name ...), is
+	// replaced by body.
+	defcomponentTag = "defcomponent"
+
+	// (slot name), in the body of a component, is replaced by the children of
+	// the (:name ...) children of the element the body replaces, and (slot)
+	// by its other children, those of the default slot.
+	slotTag = "slot"
+)
+
+// maxComponentDepth bounds how deeply components may nest, so that one using
+// itself fails rather than expanding forever.
+const maxComponentDepth = 64
+
+// ExpandComponents returns a copy of the document rooted at root with its
+// components expanded, and their definitions removed.  A component fills the
+// slots of its body with the children of the element it replaces, as in
+//
+//	(defcomponent card (div.card (h2 (slot title)) (slot)))
+//	(card (:title "Hello") (p one) (p two))
+//
+// which yields (div.card (h2 "Hello") (p one) (p two)).  Children may use
+// components in turn.  It fails on a child for a slot the component does not
+// have, on attributes given to a component, and on a definition that is not
+// at the top level.  A document with no defcomponent is returned as is.
+func ExpandComponents(root *Node) (*Node, error) {
+	if root == nil || root.Find(func(n *Node) bool {
+		return n.kind == ElementNode && n.tag == defcomponentTag
+	}) == nil {
+		return root, nil
+	}
+	top := []*Node{root}
+	if root.kind == ElementNode && root.tag == "" {
+		top = root.content
+	}
+	e := componentExpander{components: map[string][]*Node{}}
+	rest := []*Node{}
+	for _, n := range top {
+		if n.kind != ElementNode || n.tag != defcomponentTag {
+			rest = append(rest, n)
+			continue
+		}
+		if len(n.content) == 0 || n.content[0].kind != TextNode {
+			return nil, fmt.Errorf("%s: want (%s name body...)", defcomponentTag, defcomponentTag)
+		}
+		name := n.content[0].tag
+		if _, has := e.components[name]; has {
+			return nil, fmt.Errorf("%s: %s is defined twice", defcomponentTag, name)
+		}
+		e.components[name] = n.content[1:]
+	}
+	nodes, err := e.expandAll(rest, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 1 && root.tag != "" {
+		return nodes[0], nil
+	}
+	return Element("", nodes...), nil
+}
+
+// componentExpander holds the bodies of the components of a document, by
+// name.
+type componentExpander struct {
+	components map[string][]*Node
+}
+
+func (e *componentExpander) expandAll(nodes []*Node, slots map[string][]*Node, depth int) ([]*Node, error) {
+	expanded := []*Node{}
+	for _, n := range nodes {
+		ns, err := e.expand(n, slots, depth)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, ns...)
+	}
+	return expanded, nil
+}
+
+// expand returns a copy of n with its components expanded.  slots, unless
+// nil, holds the expanded children filling each slot of the body n is part
+// of, by slot name.
+func (e *componentExpander) expand(n *Node, slots map[string][]*Node, depth int) ([]*Node, error) {
+	if n.kind != ElementNode {
+		return []*Node{NewNode(n.kind, n.tag)}, nil
+	}
+	switch body, isComponent := e.components[n.tag]; {
+	case n.tag == defcomponentTag:
+		return nil, fmt.Errorf("%s: may only be at the top level", defcomponentTag)
+	case n.tag == slotTag && slots != nil:
+		if len(n.content) > 1 || len(n.content) == 1 && n.content[0].kind != TextNode {
+			return nil, fmt.Errorf("%s: want (%s [name])", slotTag, slotTag)
+		}
+		name := ""
+		if len(n.content) == 1 {
+			name = n.content[0].tag
+		}
+		filled := []*Node{}
+		for _, c := range slots[name] {
+			filled = append(filled, copyTree(c))
+		}
+		return filled, nil
+	case isComponent:
+		return e.expandComponent(n, body, slots, depth)
+	}
+
+	c := NewNode(ElementNode, n.tag)
+	for k, v := range n.attr {
+		c.attr[k] = v
+		c.setRawAttr(k, n.rawAttr[k])
+	}
+	children, err := e.expandAll(n.content, slots, depth)
+	if err != nil {
+		return nil, err
+	}
+	c.content = children
+	return []*Node{c}, nil
+}
+
+// expandComponent returns body with its slots filled with the children of n,
+// expanded with the slots of the body n is part of.
+func (e *componentExpander) expandComponent(n *Node, body []*Node, outer map[string][]*Node, depth int) ([]*Node, error) {
+	if depth >= maxComponentDepth {
+		return nil, fmt.Errorf("%s: components nest over %d deep; does one use itself?", n.tag, maxComponentDepth)
+	}
+	if len(n.attr) > 0 {
+		return nil, fmt.Errorf("%s: components take no attributes", n.tag)
+	}
+	names := map[string]bool{}
+	for _, b := range body {
+		b.Walk(func(s *Node) bool {
+			if s.kind == ElementNode && s.tag == slotTag && len(s.content) <= 1 {
+				if len(s.content) == 0 {
+					names[""] = true
+				} else {
+					names[s.content[0].tag] = true
+				}
+			}
+			return true
+		})
+	}
+	slots := map[string][]*Node{}
+	for _, c := range n.content {
+		name, group := "", []*Node{c}
+		if c.kind == ElementNode && strings.HasPrefix(c.tag, ":") {
+			name, group = c.tag[1:], c.content
+		}
+		if !names[name] {
+			if name == "" {
+				return nil, fmt.Errorf("%s: has no (%s) for children outside a named slot", n.tag, slotTag)
+			}
+			return nil, fmt.Errorf("%s: unknown slot %s", n.tag, name)
+		}
+		filled, err := e.expandAll(group, outer, depth)
+		if err != nil {
+			return nil, err
+		}
+		slots[name] = append(slots[name], filled...)
+	}
+	return e.expandAll(body, slots, depth+1)
+}
+
+// copyTree returns a deep copy of n.
+func copyTree(n *Node) *Node {
+	c := NewNode(n.kind, n.tag)
+	for k, v := range n.attr {
+		c.attr[k] = v
+		c.setRawAttr(k, n.rawAttr[k])
+	}
+	for _, child := range n.content {
+		c.content = append(c.content, copyTree(child))
+	}
+	return c
+}
@@ -0,0 +1,59 @@
+package htl
+
+import "testing"
+
+func TestExpandComponents(t *testing.T) {
+	layout := `(defcomponent layout
+  (header (slot header))
+  (main (slot main))
+  (aside (slot)))
+`
+	cases := []struct {
+		in   string
+		want string // "" for an error.
+	}{
+		{layout + "(layout (:header (h1 Title)) (:main (p one) (p two)) (p extra) (b more))",
+			"<header><h1>Title</h1></header><main><p>one</p><p>two</p></main><aside><p>extra</p><b>more</b></aside>"},
+		{layout + "(div#page (layout (:main x)))",
+			"<div id=\"page\"><header></header><main>x</main><aside></aside></div>"},
+		{layout + "(defcomponent card (div.card (slot)))\n(layout (:main (card (card deep))))",
+			"<header></header><main><div class=\"card\"><div class=\"card\">deep</div></div></main><aside></aside>"},
+		// A slot's children come from the caller, whose own slots they may use.
+		{"(defcomponent inner (b (slot)))\n(defcomponent outer (p (inner (slot msg))))\n(outer (:msg hi))",
+			"<p><b>hi</b></p>"},
+		{"(defcomponent twice (slot) (slot))\n(twice (i x))", "<i>x</i><i>x</i>"},
+		{"(p (slot name))", "<p><slot>name</slot></p>"},
+
+		{layout + "(layout (:footer x))", ""},
+		{"(defcomponent named (p (slot a)))\n(named x)", ""},
+		{layout + "(layout :id x)", ""},
+		{"(defcomponent loop (loop))\n(loop)", ""},
+		{"(defcomponent a x)\n(defcomponent a y)", ""},
+		{"(defcomponent (p))", ""},
+		{"(defcomponent a x)\n(p (defcomponent b y))", ""},
+	}
+	for _, c := range cases {
+		root, err := Parse(c.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.in, err)
+		}
+		before := root.String()
+		expanded, err := ExpandComponents(root)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("ExpandComponents(%q) = %q, want an error", c.in, expanded)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ExpandComponents(%q): %v", c.in, err)
+			continue
+		}
+		if got := expanded.String(); got != c.want {
+			t.Errorf("ExpandComponents(%q):\n  got: %q\n want: %q", c.in, got, c.want)
+		}
+		if root.String() != before {
+			t.Errorf("ExpandComponents(%q) modified its input", c.in)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package htl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dataPrefix starts the names of the custom data attributes of html, which
+// scripts read through the dataset of the element.
+const dataPrefix = "data-"
+
+// NormalizeDataAttrs renames, in the tree rooted at root, the custom data
+// attributes written in camelCase to the dashed names html expects, the way
+// dataset maps its keys to attributes: :dataUserId and :data-userId both
+// become data-user-id.  Attribute names are otherwise left alone.  It returns a
+// warning, in document order, for each data attribute it leaves as it is: one
+// with no name after data-, one whose name holds a colon, which is not
+// allowed, and one whose dashed name is already set, which keeps its value.
+func NormalizeDataAttrs(root *Node) []Diagnostic {
+	var diags []Diagnostic
+	warn := func(format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{Severity: SeverityWarning, Msg: fmt.Sprintf(format, args...)})
+	}
+	root.Walk(func(n *Node) bool {
+		if n.kind != ElementNode {
+			return true
+		}
+		for _, k := range sortedAttrKeys(n) {
+			var name string
+			switch {
+			case strings.HasPrefix(k, dataPrefix):
+				name = k[len(dataPrefix):]
+			case len(k) > len("data") && strings.HasPrefix(k, "data") && isASCIIUpper(k[len("data")]):
+				name = k[len("data"):]
+			default:
+				continue
+			}
+			switch {
+			case name == "":
+				warn("%s :%s: no name after %s", n.tag, k, dataPrefix)
+				continue
+			case strings.Contains(name, ":"):
+				warn("%s :%s: data attribute names may not hold a colon", n.tag, k)
+				continue
+			}
+			dashed := dataPrefix + dashedName(name)
+			if dashed == k {
+				continue
+			}
+			if _, has := n.attr[dashed]; has {
+				warn("%s :%s: %s is set too; keeping it", n.tag, k, dashed)
+				continue
+			}
+			n.attr[dashed] = n.attr[k]
+			n.setRawAttr(dashed, n.rawAttr[k])
+			delete(n.attr, k)
+			n.setRawAttr(k, false)
+		}
+		return true
+	})
+	return diags
+}
+
+// dashedName returns the camelCase name s, like userId or UserId, as dashed
+// lowercase words: user-id.
+func dashedName(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !isASCIIUpper(c) {
+			b.WriteByte(c)
+			continue
+		}
+		if i > 0 && s[i-1] != '-' {
+			b.WriteByte('-')
+		}
+		b.WriteByte(c + 'a' - 'A')
+	}
+	return b.String()
+}
+
+func isASCIIUpper(c byte) bool {
+	return 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,52 @@
+package htl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeDataAttrs(t *testing.T) {
+	cases := []struct {
+		in    string
+		want  string
+		diags []string
+	}{
+		{"(div :dataUserId 7 :data-itemCount 3 :data-ok yes (p :dataX \"<&>\"))",
+			"<div data-item-count=\"3\" data-ok=\"yes\" data-user-id=\"7\"><p data-x=\"&lt;&amp;&gt;\"></p></div>", nil},
+		{"(a :dataset x :data y :datum z :href /)",
+			"<a data=\"y\" dataset=\"x\" datum=\"z\" href=\"/\"></a>", nil},
+		{"(div :data- x :data-a:b y :dataUserId 1 :data-user-id 2)",
+			"<div data-=\"x\" data-a:b=\"y\" data-user-id=\"2\" dataUserId=\"1\"></div>",
+			[]string{
+				"warning: div :data-: no name after data-",
+				"warning: div :data-a:b: data attribute names may not hold a colon",
+				"warning: div :dataUserId: data-user-id is set too; keeping it",
+			}},
+	}
+	for _, c := range cases {
+		root, err := Parse(c.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.in, err)
+		}
+		var diags []string
+		for _, d := range NormalizeDataAttrs(root) {
+			diags = append(diags, d.String())
+		}
+		if got := root.String(); got != c.want {
+			t.Errorf("NormalizeDataAttrs(%q):\n  got: %q\n want: %q", c.in, got, c.want)
+		}
+		if !reflect.DeepEqual(diags, c.diags) {
+			t.Errorf("NormalizeDataAttrs(%q) diagnostics:\n  got: %q\n want: %q", c.in, diags, c.diags)
+		}
+	}
+}
+
+func TestDashedName(t *testing.T) {
+	for in, want := range map[string]string{
+		"userId": "user-id", "UserId": "user-id", "x": "x", "a-B": "a-b", "aBC": "a-b-c",
+	} {
+		if got := dashedName(in); got != want {
+			t.Errorf("dashedName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
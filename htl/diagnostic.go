@@ -0,0 +1,147 @@
+package htl
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Severity says whether a Diagnostic stopped the parse.
+type Severity int
+
+const (
+	SeverityWarning Severity = iota // A problem the parse recovered from.
+	SeverityError                   // A problem the parse failed on.
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Diagnostic is a problem found while parsing, at a position in the input
+// counted from line 1 and column 1, or by Lint, at no position: line 0.
+type Diagnostic struct {
+	Severity     Severity
+	Line, Column int
+	Msg          string
+}
+
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Msg) // no position known.
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", d.Line, d.Column, d.Severity, d.Msg)
+}
+
+// ParseResult is the outcome of ParseDiagnostics: the tree, unless an error
+// stopped the parse, and the problems found, in the order found.
+type ParseResult struct {
+	Tree        *Node
+	Diagnostics []Diagnostic
+
+	err error // The *ParseError that stopped the parse.
+}
+
+// Err returns the error that stopped the parse, if any, as a *ParseError.
+// Warnings are left for the caller to look at, or to treat as errors.
+func (r *ParseResult) Err() error {
+	return r.err
+}
+
+// Warnings reports whether the parse recovered from any problem.
+func (r *ParseResult) Warnings() bool {
+	for _, d := range r.Diagnostics {
+		if d.Severity == SeverityWarning {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDiagnostics parses like ParseWithOptions, but recovers from the
+// problems ParseLenient does and reports each of them as a warning, rather
+// than failing.  Other problems still stop the parse, and are reported as an
+// error, the last diagnostic, with no tree.  Parse and its variants report no
+// warnings: every problem is an error to them.
+func ParseDiagnostics(rawInput string, opts ParseOptions) *ParseResult {
+	tree, warnings, err := parse(rawInput, opts, true, nil)
+	r := &ParseResult{Tree: tree}
+	for _, w := range warnings {
+		r.Diagnostics = append(r.Diagnostics, diagnostic(SeverityWarning, w))
+	}
+	if err != nil {
+		r.Diagnostics = append(r.Diagnostics, diagnostic(SeverityError, err))
+		r.err = err
+	}
+	return r
+}
+
+// diagnostic returns err, a *ParseError, as a Diagnostic of severity s.
+func diagnostic(s Severity, err error) Diagnostic {
+	d := Diagnostic{Severity: s, Msg: err.Error()}
+	if pe, ok := err.(*ParseError); ok {
+		d.Line, d.Column, d.Msg = pe.Line, pe.Column, pe.Msg
+	}
+	return d
+}
+
+// FormatError returns err, from parsing src, as its position and message
+// followed by the line of src it is on and a caret under the offending rune,
+// or just past the end of the input, like compilers print errors:
+//
+//	1:10: unexpected closing paren
+//	(p "é" b))
+//	         ^
+//
+// The caret is aligned for a terminal: tabs before it are kept as tabs, wide
+// runes, like those of Chinese, take two columns, and combining marks none.
+func FormatError(src string, err *ParseError) string {
+	msg := fmt.Sprintf("%d:%d: %s", err.Line, err.Column, err.Msg)
+	lines := strings.Split(strings.TrimPrefix(src, byteOrderMark), "\n")
+	if err.Line < 1 || err.Line > len(lines) {
+		return msg
+	}
+	line := strings.TrimSuffix(lines[err.Line-1], "\r")
+	before := err.Column - 1 // runes before the caret.
+	if err.Rune == EndOfInput {
+		before = err.Column
+	}
+	var pad strings.Builder
+	for i, r := range []rune(line) {
+		if i >= before {
+			break
+		}
+		switch {
+		case r == '\t':
+			pad.WriteByte('\t')
+		case unicode.Is(unicode.Mn, r):
+		case isWide(r):
+			pad.WriteString("  ")
+		default:
+			pad.WriteByte(' ')
+		}
+	}
+	return msg + "\n" + line + "\n" + pad.String() + "^"
+}
+
+// wideRanges are the ranges of runes a terminal shows two columns wide: those
+// of East Asian scripts, full-width forms and emoji.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F}, {0x2E80, 0x303E}, {0x3041, 0x33FF}, {0x3400, 0x4DBF},
+	{0x4E00, 0x9FFF}, {0xA000, 0xA4CF}, {0xAC00, 0xD7A3}, {0xF900, 0xFAFF},
+	{0xFE30, 0xFE4F}, {0xFF00, 0xFF60}, {0xFFE0, 0xFFE6}, {0x1F300, 0x1F64F},
+	{0x1F900, 0x1F9FF}, {0x20000, 0x3FFFD},
+}
+
+// isWide reports whether r is shown two columns wide.
+func isWide(r rune) bool {
+	for _, wr := range wideRanges {
+		if r >= wr[0] && r <= wr[1] {
+			return true
+		}
+	}
+	return false
+}
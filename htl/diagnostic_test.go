@@ -0,0 +1,72 @@
+package htl
+
+import "testing"
+
+func TestParseDiagnostics(t *testing.T) {
+	cases := []struct {
+		in       string
+		want     string
+		warnings bool
+		diags    []Diagnostic
+	}{
+		{"(a b)", "<a>b</a>", false, nil},
+		{"(a(b(c))))\n(d (e)", "<a><b><c></c></b></a><d><e></e></d>", true, []Diagnostic{
+			{SeverityWarning, 1, 10, "ignoring unexpected closing paren"},
+			{SeverityWarning, 2, 6, "closing 1 elements left open"},
+		}},
+		{"(a) (b :x (c))", "", false, []Diagnostic{
+			{SeverityError, 1, 13, "unknown attribute value form \"c\""},
+		}},
+	}
+	for _, c := range cases {
+		r := ParseDiagnostics(c.in, ParseOptions{})
+		if got := r.Tree.String(); got != c.want {
+			t.Errorf("ParseDiagnostics(%q).Tree:\n  got: %q\n want: %q", c.in, got, c.want)
+		}
+		if r.Warnings() != c.warnings {
+			t.Errorf("ParseDiagnostics(%q).Warnings() = %v, want %v", c.in, r.Warnings(), c.warnings)
+		}
+		if len(r.Diagnostics) != len(c.diags) {
+			t.Errorf("ParseDiagnostics(%q).Diagnostics = %v, want %v", c.in, r.Diagnostics, c.diags)
+			continue
+		}
+		for i, d := range r.Diagnostics {
+			if d != c.diags[i] {
+				t.Errorf("ParseDiagnostics(%q) diagnostic %d = %v, want %v", c.in, i, d, c.diags[i])
+			}
+		}
+		if hasErr := r.Err() != nil; hasErr != (c.want == "") {
+			t.Errorf("ParseDiagnostics(%q).Err() = %v", c.in, r.Err())
+		}
+	}
+}
+
+func TestDiagnosticString(t *testing.T) {
+	d := Diagnostic{SeverityWarning, 3, 4, "ignoring unexpected closing paren"}
+	if got, want := d.String(), "3:4: warning: ignoring unexpected closing paren"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	cases := []struct{ src, want string }{
+		{"(p \"é\" b))", "1:10: unexpected closing paren\n(p \"é\" b))\n         ^"},
+		{"(p \"中文\" b))", "1:11: unexpected closing paren\n(p \"中文\" b))\n            ^"},
+		{"(a\n\t(b :x (c)))", "2:10: unknown attribute value form \"c\"\n\t(b :x (c)))\n\t        ^"},
+		{"(a\r\n  (b", "2:4: parser stack contains more than the root element.  " +
+			"Perhaps 2 closing parens are missing\n  (b\n    ^"},
+	}
+	for _, c := range cases {
+		_, err := Parse(c.src)
+		pe, ok := err.(*ParseError)
+		if !ok {
+			t.Fatalf("Parse(%q) error = %v, want a *ParseError", c.src, err)
+		}
+		if got := FormatError(c.src, pe); got != c.want {
+			t.Errorf("FormatError(%q):\n  got: %q\n want: %q", c.src, got, c.want)
+		}
+	}
+	if got, want := FormatError("", &ParseError{Line: 3, Column: 1, Msg: "x"}), "3:1: x"; got != want {
+		t.Errorf("FormatError past the input = %q, want %q", got, want)
+	}
+}
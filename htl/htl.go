@@ -5,12 +5,62 @@
 // Node{tag: "a", attr: {"href": "http://foo"}, kind: ElementNode,
 //      content: {Node{tag: "body", kind: TextNode}}}
 // It can then be turned to the string: <a href="foo">body</a>.
+//
+// A tag may carry an id and classes in the Emmet/Pug shorthand: (div#main.wide)
+// is <div class="wide" id="main"></div>, and a bare (.wide) is a div too.
+//
+// An attribute value may be computed by a sub-expression: (div :style (css
+// :color red :margin 0)) is <div style="color:red;margin:0"></div>.  css and
+// raw, below, are the only such forms; any other is an error.
+//
+// Attributes may be given anywhere among the content of an element, as in
+// (a "text" :href foo (b more)).  They are all collected onto the element,
+// and their order in the source only matters when one is given twice.
+//
+// A quoted string after a quoted attribute value is content, as in
+// (a :href "/x" "Click").  With ParseOptions.JoinAttrStrings it continues the
+// value instead, so a long one can be split across lines.
+//
+// A colon starts a keyword only at the start of a token.  Within a symbol it is
+// like any other rune, so attribute names may have a namespace, as in (use
+// :xlink:href #a), and values may hold colons, as in (a :href mailto:me).  A
+// value or text starting with a colon is quoted, as in (p :data-sel ":root"),
+// or has the colon escaped with a backslash, as in (p :data-sel \:root).
+//
+// Whitespace between tokens only separates them and is dropped, so (p a   b)
+// becomes <p>ab</p>.  Quoted strings keep every space, tab and newline in
+// them, so content whose whitespace matters, such as that of a pre element,
+// should be written as a quoted string: (pre "  indented\n  lines").
+//
+// (fragment a (b) c), or (<> a (b) c), is a fragment: its content is written
+// out in its place, with no element around it, as in a<b></b>c.  It states
+// that several nodes are meant to go together where one might be expected,
+// and may have no attributes.
+//
+// The tree holds text and attribute values as they read, unescaped, and they
+// are html-escaped when written out, except inside the raw pseudo-tag: (raw
+// "<b>&c;") is written out as <b>&c; exactly.  In an unquoted symbol given as
+// text, a character reference ending in a semicolon, such as &amp; or &copy;,
+// stands for the character it names, as in html, and is written out as that
+// character, escaped only where html needs it: (p &copy; a<b) is
+// <p>©a&lt;b</p>.  Anything else in a symbol is taken as it reads, so
+// attribute values like (a :href /x?a=1&copy=2) keep every & and are escaped
+// to href="/x?a=1&amp;copy=2".  raw may also give an attribute value, as in
+// (div :data-config (raw "{'a': 1}")), which is then written out between the
+// quotes as is, even if it contains the quote itself.  Never put untrusted
+// input in raw: it can inject arbitrary markup and script.
 package htl
 
 import (
+	"bufio"
 	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
 	"sort"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 const (
@@ -23,6 +73,17 @@ const (
 	keywordStartRune = ':'
 	commentStartRune = ';'
 	newLineRune      = '\n'
+
+	byteOrderMark = "\uFEFF"
+
+	// The strings inside (raw "...") are written out verbatim, without html
+	// escaping, and the raw element itself has no tags.
+	rawTag = "raw"
+
+	// (fragment ...), or (<> ...), groups nodes without an element of its
+	// own: its children are written out in its place.
+	fragmentTag      = "fragment"
+	fragmentShortTag = "<>"
 )
 
 // Set of tags that look like <img k1="v1" k2="v2"/> (i.e., no closing </img>).
@@ -42,9 +103,71 @@ func htmlEscapeRune(r rune) string {
 	return string(r)
 }
 
-// This does not look correct.  It probably should not gobble up the backslash
-// character in some cases.
-func backslashUnescapeThenHtmlEscape(r rune) string {
+func htmlEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		b.WriteString(htmlEscapeRune(r))
+	}
+	return b.String()
+}
+
+// unescapeReferences returns s with each character reference ending in a
+// semicolon, like &amp;, &copy; or &#169;, replaced by the character it
+// names.  Those lacking the semicolon, which html reads too in some places,
+// are left as they are, so &copy=2 keeps its meaning.
+func unescapeReferences(s string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '&')
+		if i < 0 {
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		n := referenceLen(s)
+		if n == 0 {
+			b.WriteByte('&')
+			s = s[1:]
+			continue
+		}
+		b.WriteString(html.UnescapeString(s[:n]))
+		s = s[n:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// referenceLen returns the length of the character reference ending in a
+// semicolon that s starts with, or 0 if it starts with none.
+func referenceLen(s string) int {
+	end := strings.IndexByte(s, ';')
+	if end < 2 {
+		return 0
+	}
+	name := s[1:end]
+	if name[0] == '#' {
+		digits, valid := name[1:], "0123456789"
+		if strings.HasPrefix(digits, "x") || strings.HasPrefix(digits, "X") {
+			digits, valid = digits[1:], "0123456789abcdefABCDEF"
+		}
+		if digits == "" || strings.Trim(digits, valid) != "" {
+			return 0
+		}
+		return end + 1
+	}
+	if strings.TrimLeft(name, "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return 0
+	}
+	// A known name decodes whole; a longer one, like &copyx;, only in part.
+	if decoded := html.UnescapeString(s[:end+1]); decoded == s[:end+1] || strings.HasSuffix(decoded, ";") {
+		return 0
+	}
+	return end + 1
+}
+
+// backslashUnescape returns what the escape sequence \r stands for: r itself
+// unless it is one of f, n, r, t and v.
+func backslashUnescape(r rune) string {
 	switch r {
 	case 'f':
 		return "\f"
@@ -57,15 +180,22 @@ func backslashUnescapeThenHtmlEscape(r rune) string {
 	case 'v':
 		return "\v"
 	default:
-		return htmlEscapeRune(r) // backslash and doublequote are also covered here.
+		return string(r) // backslash and doublequote are also covered here.
 	}
 }
 
+// isKnownEscape reports whether \r is an escape sequence backslashUnescape
+// knows, rather than one passing r through.
+func isKnownEscape(r rune) bool {
+	return strings.ContainsRune("fnrtv\\\"", r)
+}
+
 type NodeType int
 
 const (
 	ElementNode NodeType = iota // An HTML Element node.
-	TextNode                    // Only text (stored in node.tag).
+	TextNode                    // Only text (stored in node.tag, unescaped).
+	RawNode                     // Text that is written out verbatim.
 )
 
 type Node struct {
@@ -73,6 +203,7 @@ type Node struct {
 	tag     string
 	attr    map[string]string
 	content []*Node
+	rawAttr map[string]bool // Attributes written out without escaping.
 }
 
 func NewNode(kind NodeType, tag string) *Node {
@@ -84,6 +215,63 @@ func NewNode(kind NodeType, tag string) *Node {
 	}
 }
 
+// Element returns an element node with the given tag and children.
+func Element(tag string, children ...*Node) *Node {
+	n := NewNode(ElementNode, tag)
+	n.content = append(n.content, children...)
+	return n
+}
+
+// Fragment returns a fragment holding children, which are written out with no
+// element around them.
+func Fragment(children ...*Node) *Node {
+	return Element(fragmentTag, children...)
+}
+
+// Text returns a text node holding s, which is html-escaped when written out.
+func Text(s string) *Node {
+	return NewNode(TextNode, s)
+}
+
+// Raw returns a node holding s, which is written out verbatim: it is up to the
+// caller to make sure s is safe html.
+func Raw(s string) *Node {
+	return NewNode(RawNode, s)
+}
+
+// SetAttr sets the value of attribute key, which is html-escaped when written
+// out, and returns n, so calls can be chained while building a tree.
+func (n *Node) SetAttr(key, value string) *Node {
+	n.attr[key] = value
+	delete(n.rawAttr, key)
+	return n
+}
+
+// SetRawAttr sets attribute key to value, which is written out verbatim: it is
+// up to the caller to make sure value is safe and does not contain the quote.
+func (n *Node) SetRawAttr(key, value string) *Node {
+	n.attr[key] = value
+	n.setRawAttr(key, true)
+	return n
+}
+
+// Append adds children after the content of n and returns n.
+func (n *Node) Append(children ...*Node) *Node {
+	n.content = append(n.content, children...)
+	return n
+}
+
+func (n *Node) setRawAttr(key string, raw bool) {
+	if !raw {
+		delete(n.rawAttr, key)
+		return
+	}
+	if n.rawAttr == nil {
+		n.rawAttr = map[string]bool{}
+	}
+	n.rawAttr[key] = true
+}
+
 type contextType int
 
 const (
@@ -93,15 +281,67 @@ const (
 	contextAttrKey
 	contextAfterAttrKey
 	contextAttrValue
+	contextAfterAttrString // A quoted attribute value, which a string may continue.
 	contextContent
 )
 
 type ParseState struct {
 	context           contextType
-	token             string // we keep appending to it.
+	token             []byte // we keep appending to it.
 	key               string
 	escapingBackslash bool
 	stack             []*Node
+
+	// shorthand records, per element, the attributes set by its tag
+	// shorthand (as in div#main.wide) and not yet by a keyword.
+	shorthand map[*Node]map[string]bool
+
+	opts ParseOptions
+
+	lenient  bool     // Recover from unbalanced parens.
+	warnings []string // Recovered problems, not yet given a position.
+
+	// forms holds the sub-expressions being read in attribute-value
+	// position, as in (div :style (css :color red)).
+	forms map[*Node]*attrForm
+
+	rawValue bool // The value being committed came from a raw form.
+
+	continuing bool // The value being committed continues that of ps.key.
+
+	err string // Why parsing failed, once an eatFn returns nil.
+
+	// spans, if not nil, receives the byte offsets each node starts and ends
+	// at.  pos is the offset of the rune being eaten, and start and end
+	// those of the pending token.
+	spans           map[*Node][2]int
+	pos, start, end int
+}
+
+// setSpan records that node n spans the input from byte start to end.
+func (ps *ParseState) setSpan(n *Node, start, end int) {
+	if ps.spans != nil {
+		ps.spans[n] = [2]int{start, end}
+	}
+}
+
+// attrForm is a sub-expression computing the value of attribute key.
+type attrForm struct {
+	key  string   // Attribute the form computes.
+	keys []string // Keywords of the form, in the order written.
+}
+
+// attrValueForms compute an attribute value from the keywords of a
+// sub-expression written in its place, given in the order written.
+var attrValueForms = map[string]func(keys []string, attr map[string]string) string{
+	// (css :color red :margin 0) is color:red;margin:0.
+	"css": func(keys []string, attr map[string]string) string {
+		decls := make([]string, len(keys))
+		for i, k := range keys {
+			decls[i] = k + ":" + attr[k]
+		}
+		return strings.Join(decls, ";")
+	},
 }
 
 // eatFn "eats" a rune, reads and possibly alters ParseState and returns the
@@ -109,7 +349,7 @@ type ParseState struct {
 type eatFn func(r rune, ps *ParseState) eatFn
 
 func (ps *ParseState) error(s string) eatFn {
-	ps.token = s
+	ps.err = s
 	return nil
 }
 
@@ -121,14 +361,37 @@ func (ps *ParseState) currentNode() *Node {
 }
 
 func (ps *ParseState) flushToken() (s string) {
-	s, ps.token = ps.token, ""
+	s, ps.token = string(ps.token), ps.token[:0]
 	return s
 }
 
-func (ps *ParseState) commit() {
+// commit stores the pending token according to the context.  On failure it
+// records the error the way ps.error does and returns false.
+func (ps *ParseState) commit() bool {
 	switch ps.context {
 	case contextTag:
-		ps.currentNode().tag = ps.flushToken()
+		node := ps.currentNode()
+		tag, id, classes, err := splitTag(ps.flushToken())
+		if err != "" {
+			ps.error(err)
+			return false
+		}
+		if _, has := attrValueForms[tag]; ps.forms[node] != nil && !has && tag != rawTag {
+			ps.error(fmt.Sprintf("unknown attribute value form %q", tag))
+			return false
+		}
+		if tag == fragmentShortTag {
+			tag = fragmentTag
+		}
+		node.tag = tag
+		if id != "" {
+			node.attr["id"] = id
+			ps.markShorthand(node, "id")
+		}
+		if len(classes) > 0 {
+			node.attr["class"] = strings.Join(classes, " ")
+			ps.markShorthand(node, "class")
+		}
 
 	case contextAttrKey:
 		ps.key = ps.flushToken()
@@ -136,14 +399,135 @@ func (ps *ParseState) commit() {
 	case contextAttrValue:
 		key := ""
 		key, ps.key = ps.key, key
-		ps.currentNode().attr[key] = ps.flushToken()
+		node := ps.currentNode()
+		value := ps.flushToken()
+		if ps.opts.LowercaseAttrs && !ps.inForeignContent() && !strings.Contains(key, ":") {
+			key = strings.ToLower(key)
+		}
+		if ps.continuing {
+			ps.continuing = false
+			node.attr[key] += value
+			return true
+		}
+		if ps.shorthand[node][key] {
+			delete(ps.shorthand[node], key)
+			switch key {
+			case "id":
+				ps.error("id given both in the tag and as :id")
+				return false
+			case "class":
+				value = node.attr[key] + " " + value
+			}
+		} else if form := ps.forms[node]; form != nil {
+			if _, has := node.attr[key]; !has {
+				form.keys = append(form.keys, key)
+			}
+		} else if old, has := node.attr[key]; has {
+			switch ps.opts.DuplicateAttrs {
+			case DuplicateAttrsError:
+				ps.error("duplicate attribute " + key)
+				return false
+			case DuplicateAttrsMerge:
+				value = mergeAttr(key, old, value)
+			}
+		}
+		node.attr[key] = value
+		node.setRawAttr(key, ps.rawValue)
+		ps.rawValue = false
 
 	case contextContent:
 		node := ps.currentNode()
-		node.content = append(node.content, NewNode(TextNode, ps.flushToken()))
+		kind := TextNode
+		if node.tag == rawTag {
+			kind = RawNode
+		}
+		text := NewNode(kind, ps.flushToken())
+		node.content = append(node.content, text)
+		ps.setSpan(text, ps.start, ps.end)
 
 	default: // noop
 	}
+	return true
+}
+
+// commitSymbol commits the pending token, an unquoted symbol.  As text, the
+// character references in it ending in a semicolon, like &amp; or &copy;,
+// stand for the characters they name, as they would in html.  Elsewhere, as
+// in attribute values, it is taken as it reads.
+func (ps *ParseState) commitSymbol() bool {
+	if ps.context == contextContent {
+		ps.token = append(ps.token[:0], unescapeReferences(string(ps.token))...)
+	}
+	return ps.commit()
+}
+
+// inForeignContent reports whether the current node is in an svg or math
+// element, whose attribute names are case-sensitive.
+func (ps *ParseState) inForeignContent() bool {
+	for _, n := range ps.stack {
+		if n.tag == "svg" || n.tag == "math" {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeAttr joins two values of a class or style attribute.  For other
+// attributes the new value replaces the old one.
+func mergeAttr(key, old, value string) string {
+	switch key {
+	case "class":
+		return old + " " + value
+	case "style":
+		return strings.TrimSuffix(old, ";") + ";" + value
+	}
+	return value
+}
+
+func (ps *ParseState) markShorthand(node *Node, key string) {
+	if ps.shorthand == nil {
+		ps.shorthand = map[*Node]map[string]bool{}
+	}
+	if ps.shorthand[node] == nil {
+		ps.shorthand[node] = map[string]bool{}
+	}
+	ps.shorthand[node][key] = true
+}
+
+// splitTag splits a tag written in the Emmet/Pug shorthand, like
+// div#main.a.b, into its tag (div), id (main) and classes (a and b).  The tag
+// defaults to div when only an id or classes are given.  An explicit :class
+// keyword adds to the classes of the shorthand; an explicit :id is an error.
+func splitTag(s string) (tag, id string, classes []string, err string) {
+	i := strings.IndexAny(s, "#.")
+	if i < 0 {
+		return s, "", nil, ""
+	}
+	tag, s = s[:i], s[i:]
+	if tag == "" {
+		tag = "div"
+	}
+	for s != "" {
+		sep := s[0]
+		s = s[1:]
+		end := strings.IndexAny(s, "#.")
+		if end < 0 {
+			end = len(s)
+		}
+		name := s[:end]
+		s = s[end:]
+		switch {
+		case name == "":
+			return "", "", nil, "empty id or class in tag shorthand"
+		case sep == '.':
+			classes = append(classes, name)
+		case id != "":
+			return "", "", nil, "more than one id in tag shorthand"
+		default:
+			id = name
+		}
+	}
+	return tag, id, classes, ""
 }
 
 func (ps *ParseState) push() eatFn {
@@ -152,30 +536,98 @@ func (ps *ParseState) push() eatFn {
 	if len(ps.stack) >= maxStackDepth {
 		return ps.error("tree too deep")
 	}
+	if parent != nil && parent.tag == rawTag {
+		return ps.error("raw may only contain strings")
+	}
 	ps.stack = append(ps.stack, newNode) // push into the stack.
 	if parent != nil {
 		parent.content = append(parent.content, newNode)
 	}
+	ps.setSpan(newNode, ps.pos, ps.pos)
+	ps.context = contextTag
+	return eatSymbol
+}
+
+// pushAttrForm starts a sub-expression computing the value of the pending
+// attribute.  Unlike an element, it is not added to the content of its parent.
+func (ps *ParseState) pushAttrForm() eatFn {
+	if len(ps.stack) >= maxStackDepth {
+		return ps.error("tree too deep")
+	}
+	newNode := NewNode(ElementNode, "")
+	if ps.forms == nil {
+		ps.forms = map[*Node]*attrForm{}
+	}
+	ps.forms[newNode] = &attrForm{key: ps.key}
+	ps.key = ""
+	ps.stack = append(ps.stack, newNode)
 	ps.context = contextTag
 	return eatSymbol
 }
 
+// popAttrForm ends the sub-expression node and sets the attribute it computes
+// on its parent.
+func (ps *ParseState) popAttrForm(node *Node) eatFn {
+	form := ps.forms[node]
+	delete(ps.forms, node)
+	value := ""
+	if node.tag == rawTag {
+		if len(node.attr) > 0 {
+			return ps.error("raw may only contain strings")
+		}
+		for _, c := range node.content {
+			value += c.tag
+		}
+		ps.rawValue = true
+	} else {
+		if len(node.content) > 0 {
+			return ps.error(node.tag + " may only contain keywords")
+		}
+		value = attrValueForms[node.tag](form.keys, node.attr)
+	}
+	ps.stack = ps.stack[0 : len(ps.stack)-1]
+	ps.context, ps.key, ps.token = contextAttrValue, form.key, append(ps.token[:0], value...)
+	if !ps.commit() {
+		return nil
+	}
+	ps.context = contextAfterTag
+	return eatAir
+}
+
 func (ps *ParseState) pop() eatFn {
+	if node := ps.currentNode(); ps.forms[node] != nil {
+		return ps.popAttrForm(node)
+	}
 	if len(ps.stack) > 1 {
+		node := ps.currentNode()
+		if node.tag == fragmentTag && len(node.attr) > 0 {
+			return ps.error("fragment may not have attributes")
+		}
+		ps.setSpan(node, ps.spans[node][0], ps.pos+1)
 		ps.stack = ps.stack[0 : len(ps.stack)-1]
 		ps.context = contextDefault
 		return eatAir
 	}
+	if ps.lenient {
+		ps.warn("ignoring unexpected closing paren")
+		ps.context = contextDefault
+		return eatAir
+	}
 	return ps.error("unexpected closing paren")
 }
 
+// warn records a problem that a lenient parse recovers from.
+func (ps *ParseState) warn(s string) {
+	ps.warnings = append(ps.warnings, s)
+}
+
 // We have 3 eatFn: eatAir (consuming space between "tokens"), eatSymbol
 // (consuming a symbol), eatString (consuming a string).
 func eatAir(r rune, ps *ParseState) eatFn {
 	switch {
 	case r == openParenRune:
 		if ps.context == contextAfterAttrKey {
-			return ps.error("unexpected open paren")
+			return ps.pushAttrForm()
 		}
 		return ps.push()
 
@@ -186,31 +638,40 @@ func eatAir(r rune, ps *ParseState) eatFn {
 		return ps.pop()
 
 	case r == quoteRune:
+		ps.start = ps.pos
 		if ps.context == contextAfterAttrKey {
 			ps.context = contextAttrValue
 			return eatString
 		}
+		if ps.context == contextAfterAttrString {
+			ps.context, ps.continuing = contextAttrValue, true
+			return eatString
+		}
 		ps.context = contextContent // or contextDefault?
 		return eatString
 
-	case r == commentStartRune:
+	case r == ps.opts.commentRune():
 		return eatComment
 
 	case r == keywordStartRune:
-		if ps.context == contextAfterTag {
+		afterToken := ps.context == contextAfterTag || ps.context == contextDefault ||
+			ps.context == contextAfterAttrString
+		if afterToken && len(ps.stack) > 1 { // not the root.
 			ps.context = contextAttrKey
 			return eatSymbol
 		}
 		return ps.error("unexpect character")
 
 	case r == escapingRune:
-		return ps.error("backslash-escaping not allowed here")
+		ps.start = ps.pos
+		return eatEscapedColon
 
 	case unicode.IsSpace(r):
 		return eatAir
 
 	default:
-		ps.token += string(r)
+		ps.token = utf8.AppendRune(ps.token, r)
+		ps.start = ps.pos
 		if ps.context == contextAfterAttrKey {
 			ps.context = contextAttrValue
 		} else {
@@ -221,35 +682,45 @@ func eatAir(r rune, ps *ParseState) eatFn {
 }
 
 func eatSymbol(r rune, ps *ParseState) eatFn {
+	ps.end = ps.pos
 	switch {
 	case r == openParenRune:
 		if ps.context == contextAttrKey {
 			return ps.error("unexpected open paren")
 		}
-		ps.commit()
+		if !ps.commitSymbol() {
+			return nil
+		}
 		return ps.push()
 
 	case r == closeParenRune:
 		if ps.context == contextAttrKey {
 			return ps.error("unexpected close paren")
 		}
-		ps.commit()
+		if !ps.commitSymbol() {
+			return nil
+		}
 		return ps.pop()
 
 	case r == quoteRune:
-		ps.commit()
+		if !ps.commitSymbol() {
+			return nil
+		}
 		if ps.context == contextAttrKey {
 			ps.context = contextAttrValue
 		} else {
 			ps.context = contextContent
 		}
+		ps.start = ps.pos
 		return eatString
 
 	case r == escapingRune:
 		return ps.error("backslash-escaping is not allowed here")
 
 	case unicode.IsSpace(r):
-		ps.commit()
+		if !ps.commitSymbol() {
+			return nil
+		}
 		if ps.context == contextAttrKey {
 			ps.context = contextAfterAttrKey
 		} else {
@@ -258,21 +729,45 @@ func eatSymbol(r rune, ps *ParseState) eatFn {
 		return eatAir
 
 	default:
-		ps.token += string(r)
+		ps.token = utf8.AppendRune(ps.token, r)
 		return eatSymbol
 	}
 }
 
+// eatEscapedColon consumes the colon after a backslash starting a symbol, as
+// in \:root, which then starts with the colon rather than being a keyword.
+func eatEscapedColon(r rune, ps *ParseState) eatFn {
+	if r != keywordStartRune {
+		return ps.error("backslash-escaping is only allowed for a colon starting a symbol")
+	}
+	ps.token = utf8.AppendRune(ps.token, r)
+	if ps.context == contextAfterAttrKey {
+		ps.context = contextAttrValue
+	} else {
+		ps.context = contextContent
+	}
+	return eatSymbol
+}
+
 func eatString(r rune, ps *ParseState) eatFn {
 	if ps.escapingBackslash {
 		ps.escapingBackslash = false
-		ps.token += backslashUnescapeThenHtmlEscape(r)
+		if ps.opts.StrictEscapes && !isKnownEscape(r) {
+			return ps.error(fmt.Sprintf("unknown escape sequence \\%c", r))
+		}
+		ps.token = append(ps.token, backslashUnescape(r)...)
 		return eatString
 	}
 
 	if r == quoteRune {
-		ps.commit()
-		if ps.context == contextAttrValue {
+		ps.end = ps.pos + 1
+		key := ps.key
+		if !ps.commit() {
+			return nil
+		}
+		if ps.context == contextAttrValue && ps.opts.JoinAttrStrings {
+			ps.context, ps.key = contextAfterAttrString, key
+		} else if ps.context == contextAttrValue {
 			ps.context = contextAfterTag
 		} else {
 			ps.context = contextDefault
@@ -284,7 +779,7 @@ func eatString(r rune, ps *ParseState) eatFn {
 		ps.escapingBackslash = true
 		return eatString
 	}
-	ps.token += htmlEscapeRune(r)
+	ps.token = utf8.AppendRune(ps.token, r)
 	return eatString
 }
 
@@ -295,22 +790,276 @@ func eatComment(r rune, ps *ParseState) eatFn {
 	return eatComment
 }
 
+// EndOfInput is the Rune of a ParseError found at the end of the input.
+const EndOfInput rune = -1
+
+// ParseError describes where and why parsing failed.
+type ParseError struct {
+	Line, Column int    // Position of Rune, counting from 1.
+	Msg          string // Reason for the failure.
+	Rune         rune   // The offending rune, or EndOfInput.
+}
+
+func (e *ParseError) Error() string {
+	if e.Rune == EndOfInput {
+		return fmt.Sprintf("Error at end of input (line %d column %d).  %s.",
+			e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("Error processing rune %q (line %d column %d).  %s.",
+		e.Rune, e.Line, e.Column, e.Msg)
+}
+
+// ParseOptions controls parsing.  The zero value parses the same way Parse
+// does.
+type ParseOptions struct {
+	// RejectInvalidUTF8 makes invalid UTF-8 in the input an error.  Otherwise
+	// each invalid byte is read as U+FFFD, the replacement character.
+	RejectInvalidUTF8 bool
+
+	// DuplicateAttrs says what to do when an element sets an attribute more
+	// than once.
+	DuplicateAttrs DuplicateAttrsPolicy
+
+	// LowercaseAttrs lowercases attribute names, so that :Class and :class
+	// are the same attribute.  Namespaced names, like xlink:href, and the
+	// names in svg and math elements keep their case.
+	LowercaseAttrs bool
+
+	// CapturePrologue keeps the doctype declarations and processing
+	// instructions at the start of the input, like <!DOCTYPE html> or
+	// <?xml version="1.0"?>, as raw nodes written out verbatim.  Otherwise
+	// they are an error, as is any other leading html tag.
+	CapturePrologue bool
+
+	// StrictEscapes makes a backslash escape in a string other than \\, \",
+	// \f, \n, \r, \t and \v an error, as likely a typo.  Otherwise the
+	// backslash is dropped, so "\q" reads as q.
+	StrictEscapes bool
+
+	// CommentRune, where a token may start, starts a comment running to the
+	// end of the line.  It is ';' if zero, and may not be a paren, a quote, a
+	// backslash, a colon or a space.
+	CommentRune rune
+
+	// JoinAttrStrings continues a quoted attribute value through the quoted
+	// strings right after it, so (div :class "btn " "btn-primary " "large")
+	// is <div class="btn btn-primary large"></div>.  The value ends at the
+	// first other token, like a symbol, a keyword or a paren.  Otherwise a
+	// string after an attribute value is content.
+	JoinAttrStrings bool
+
+	// NoComments turns comments off, so the comment rune where a token may
+	// start, as in (p ;x), starts a symbol like any other rune.
+	NoComments bool
+}
+
+// commentRune returns the rune starting a comment, or EndOfInput, which no
+// rune of the input is, if comments are off.
+func (opts ParseOptions) commentRune() rune {
+	switch {
+	case opts.NoComments:
+		return EndOfInput
+	case opts.CommentRune == 0:
+		return commentStartRune
+	}
+	return opts.CommentRune
+}
+
+// DuplicateAttrsPolicy says what to do with an attribute set more than once.
+type DuplicateAttrsPolicy int
+
+const (
+	DuplicateAttrsLastWins DuplicateAttrsPolicy = iota // Keep the last value.
+	DuplicateAttrsMerge                                // Join class and style values; otherwise keep the last.
+	DuplicateAttrsError                                // Fail to parse.
+)
+
+// Parse parses rawInput with the default ParseOptions.  A leading byte order
+// mark is ignored, so input holding only one yields no tree and no error, as
+// empty input does.  Parse errors are of type *ParseError.
 func Parse(rawInput string) (*Node, error) {
+	return ParseWithOptions(rawInput, ParseOptions{})
+}
+
+// ParseReader is like Parse, but reads its input from r.  Errors reading r are
+// returned as is.
+func ParseReader(r io.Reader) (*Node, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(string(data))
+}
+
+// ParseWithOptions is like Parse, but honors opts.
+func ParseWithOptions(rawInput string, opts ParseOptions) (*Node, error) {
+	tree, _, err := parse(rawInput, opts, false, nil)
+	return tree, err
+}
+
+// SourceError is an error parsing one of the sources given to ParseAll.
+type SourceError struct {
+	Index int   // Index of the source among those given, counting from 0.
+	Err   error // The *ParseError, positioned within the source.
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("source %d: %v", e.Index, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// ParseAll parses each of sources like Parse and returns a root, like the one
+// Parse returns, holding the top-level nodes of all of them in order, as for
+// partials a build concatenates.  Unlike parsing the concatenated text, an
+// error is a *SourceError giving the index of the source it is in, and its
+// position within that source.
+func ParseAll(sources ...string) (*Node, error) {
+	root := NewNode(ElementNode, "")
+	for i, src := range sources {
+		tree, err := Parse(src)
+		if err != nil {
+			return nil, &SourceError{Index: i, Err: err}
+		}
+		if tree != nil {
+			root.content = append(root.content, tree.content...)
+		}
+	}
+	return root, nil
+}
+
+// ParseLenient is like Parse, but recovers from unbalanced parens the way an
+// html parser recovers from misnested tags: it ignores extra closing parens
+// and closes the elements still open at the end of the input.  Each recovery
+// is reported as a *ParseError warning.  Other errors still fail the parse, in
+// which case the tree is nil and the error comes last.
+func ParseLenient(rawInput string) (*Node, []error) {
+	tree, warnings, err := parse(rawInput, ParseOptions{}, true, nil)
+	if err != nil {
+		return nil, append(warnings, err)
+	}
+	return tree, warnings
+}
+
+// prologueEnd returns the length of the doctype declaration, like
+// <!DOCTYPE html>, or processing instruction, like <?xml version="1.0"?>,
+// that s starts with, or -1 if there is none.
+func prologueEnd(s string) int {
+	var end string
+	switch {
+	case strings.HasPrefix(s, "<?"):
+		end = "?>"
+	case strings.HasPrefix(s, "<!"):
+		end = ">"
+	default:
+		return -1
+	}
+	i := strings.Index(s, end)
+	if i < 0 {
+		return -1
+	}
+	return i + len(end)
+}
+
+// ParseWithSpans is Parse, also returning the byte offsets in s each node of
+// the tree starts and ends at, such that s[start:end] is the source of the
+// node: from its opening paren to its closing one for an element, and the
+// whole symbol or quoted string for text.  Editors can use them to highlight
+// or re-parse part of a document.
+func ParseWithSpans(s string) (*Node, map[*Node][2]int, error) {
+	spans := map[*Node][2]int{}
+	tree, _, err := parse(s, ParseOptions{}, false, spans)
+	if err != nil || tree == nil {
+		return nil, nil, err
+	}
+	inTree := map[*Node][2]int{}
+	tree.Walk(func(n *Node) bool {
+		inTree[n] = spans[n]
+		return true
+	})
+	return tree, inTree, nil
+}
+
+// parse parses rawInput, recording the span of each node in spans unless it
+// is nil.
+func parse(rawInput string, opts ParseOptions, lenient bool, spans map[*Node][2]int) (*Node, []error, error) {
+	if r := opts.commentRune(); unicode.IsSpace(r) || strings.ContainsRune("()\"\\:", r) {
+		return nil, nil, fmt.Errorf("%q cannot start comments", r)
+	}
+	inputLen := len(rawInput)
+	rawInput = strings.TrimPrefix(rawInput, byteOrderMark)
 	if rawInput == "" {
-		return nil, nil
+		return nil, nil, nil
 	}
 	rootNode := NewNode(ElementNode, "")
 	ps := ParseState{
 		context:           contextDefault,
-		token:             "",
+		token:             nil,
 		key:               "",
 		escapingBackslash: false,
 		stack:             append(make([]*Node, 0, maxStackDepth), rootNode),
+		opts:              opts,
+		lenient:           lenient,
+		spans:             spans,
 	}
+	ps.setSpan(rootNode, 0, inputLen)
+	var warnings []error
 	eater := eatAir
 	lineNumber := 1
 	columnNumber := 0
-	for _, r := range rawInput {
+	advance := func(s string) {
+		for _, r := range s {
+			if r == newLineRune {
+				lineNumber++
+				columnNumber = 0
+			} else {
+				columnNumber++
+			}
+		}
+	}
+	for {
+		// Leading markup is either a prologue to capture, or a sign that the
+		// input is html rather than htl.
+		start := len(rawInput) - len(strings.TrimLeftFunc(rawInput, unicode.IsSpace))
+		decl := rawInput[start:]
+		if !strings.HasPrefix(decl, "<") {
+			break
+		}
+		advance(rawInput[:start+1])
+		end := prologueEnd(decl)
+		if !opts.CapturePrologue || end < 0 {
+			msg := "HTL uses S-expression syntax, like (p \"text\"), not html tags; " +
+				"did you mean to write an HTL document?"
+			if opts.CapturePrologue && (strings.HasPrefix(decl, "<!") || strings.HasPrefix(decl, "<?")) {
+				msg = "unterminated doctype or processing instruction"
+			}
+			return nil, warnings, &ParseError{
+				Line:   lineNumber,
+				Column: columnNumber,
+				Rune:   '<',
+				Msg:    msg,
+			}
+		}
+		prologue := NewNode(RawNode, decl[:end])
+		rootNode.content = append(rootNode.content, prologue)
+		ps.setSpan(prologue, inputLen-len(decl), inputLen-len(decl)+end)
+		advance(decl[1:end])
+		rawInput = decl[end:]
+	}
+	for i, r := range rawInput {
+		if r == utf8.RuneError && opts.RejectInvalidUTF8 {
+			if _, size := utf8.DecodeRuneInString(rawInput[i:]); size == 1 {
+				return nil, warnings, &ParseError{
+					Line:   lineNumber,
+					Column: columnNumber + 1,
+					Rune:   r,
+					Msg:    fmt.Sprintf("invalid UTF-8 byte %#x", rawInput[i]),
+				}
+			}
+		}
+		ps.pos = inputLen - len(rawInput) + i
 		eater = eater(r, &ps)
 		if r == newLineRune {
 			lineNumber++
@@ -318,18 +1067,64 @@ func Parse(rawInput string) (*Node, error) {
 		} else {
 			columnNumber++
 		}
+		for _, msg := range ps.warnings {
+			warnings = append(warnings, &ParseError{
+				Line:   lineNumber,
+				Column: columnNumber,
+				Rune:   r,
+				Msg:    msg,
+			})
+		}
+		ps.warnings = nil
 		if eater == nil {
-			return nil, fmt.Errorf(
-				"Error processing rune %q (line %d column %d).  %s.",
-				r, lineNumber, columnNumber, ps.token)
+			return nil, warnings, &ParseError{
+				Line:   lineNumber,
+				Column: columnNumber,
+				Rune:   r,
+				Msg:    ps.err,
+			}
 		}
 	}
-	if len(ps.stack) > 1 {
-		return nil, fmt.Errorf(
-			"Parser stack contains more than the root element.  "+
-				"Perhaps %d closing parens are missing?", len(ps.stack)-1)
+	// A trailing newline commits a pending symbol and ends a comment, but
+	// only ever adds to the token of an unterminated string.
+	ps.pos = inputLen
+	if eater(newLineRune, &ps) == nil {
+		return nil, warnings, &ParseError{
+			Line:   lineNumber,
+			Column: columnNumber,
+			Rune:   EndOfInput,
+			Msg:    ps.err,
+		}
+	}
+	if len(ps.token) > 0 {
+		return nil, warnings, &ParseError{
+			Line:   lineNumber,
+			Column: columnNumber,
+			Rune:   EndOfInput,
+			Msg:    "unterminated string",
+		}
 	}
-	return ps.stack[0], nil // root node
+	if len(ps.stack) > 1 && lenient {
+		for _, n := range ps.stack[1:] {
+			ps.setSpan(n, ps.spans[n][0], inputLen)
+		}
+		warnings = append(warnings, &ParseError{
+			Line:   lineNumber,
+			Column: columnNumber,
+			Rune:   EndOfInput,
+			Msg:    fmt.Sprintf("closing %d elements left open", len(ps.stack)-1),
+		})
+	} else if len(ps.stack) > 1 {
+		return nil, warnings, &ParseError{
+			Line:   lineNumber,
+			Column: columnNumber,
+			Rune:   EndOfInput,
+			Msg: fmt.Sprintf(
+				"parser stack contains more than the root element.  "+
+					"Perhaps %d closing parens are missing", len(ps.stack)-1),
+		}
+	}
+	return ps.stack[0], warnings, nil // root node
 }
 
 // Sort a list of strings.
@@ -339,53 +1134,300 @@ func (a stringSlice) Len() int           { return len(a) }
 func (a stringSlice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a stringSlice) Less(i, j int) bool { return a[i] < a[j] }
 
+// Options controls how a tree is serialized.  The zero value serializes the
+// same way String does.
+type Options struct {
+	// Quote wraps attribute values: '"' (the default when zero) or '\''.  The
+	// chosen quote is escaped inside values; the other one is left alone.
+	Quote rune
+
+	// OmitEmptyAttrs leaves out attributes whose value is empty, such as
+	// (a :href "") or a :href "{{url}}" that Render filled with an empty
+	// string.  Note that this changes the meaning of boolean attributes: an
+	// empty :disabled "" no longer disables.
+	OmitEmptyAttrs bool
+
+	// MinimizeBooleanAttrs writes a boolean attribute of html whose value
+	// repeats its name, as (input :checked checked) does, or is empty, as just
+	// the name: <input checked/>.  Other attributes keep their values, even
+	// one repeating its name, like (input :value value).
+	MinimizeBooleanAttrs bool
+
+	// AttrOrder lists, by tag, the attributes written first, in the order
+	// given; the rest follow sorted by name.  Nil means DefaultAttrOrder, and
+	// an empty map sorts all attributes by name.
+	AttrOrder map[string][]string
+
+	// IndentBlocks, unless empty, puts each block element, like div, p or li,
+	// on a line of its own, indented by IndentBlocks once for each block
+	// element around it.  Inline elements, like a, span or b, and text stay on
+	// the line of the block holding them, and so does everything inside a pre
+	// element or an inline one, where the added whitespace would show.
+	IndentBlocks string
+
+	// Renderers maps tags, like x-icon, to functions returning the html each
+	// element of the tag is written out as, verbatim, in place of the element
+	// and its content: a way to expand custom elements when serializing, as
+	// in {"x-icon": func(n *Node) string { ... }}.  Elements of other tags are
+	// written out as usual.
+	Renderers map[string]func(*Node) string
+
+	xml bool // Serialize by the rules of XML rather than html; see XML.
+
+	depth   int  // Number of indented blocks around the node being written.
+	flat    int  // Number of elements around it whose content is not indented.
+	started bool // Whether anything has been written yet.
+}
+
+// DefaultAttrOrder puts first the attributes that matter most to a reader of
+// the html, or to a browser, such as the charset of a meta element, which
+// must come early in the document to take effect.
+var DefaultAttrOrder = map[string][]string{
+	"meta":  {"charset", "http-equiv", "name", "property"},
+	"input": {"type"},
+}
+
+// orderAttrs moves the attributes of tag listed in opts.AttrOrder to the
+// front of keys, which are sorted by name, in the order listed.
+func (opts *Options) orderAttrs(tag string, keys []string) []string {
+	order := opts.AttrOrder
+	if order == nil {
+		order = DefaultAttrOrder
+	}
+	first := order[strings.ToLower(tag)]
+	if len(first) == 0 {
+		return keys
+	}
+	ordered := make([]string, 0, len(keys))
+	listed := map[string]bool{}
+	for _, k := range first {
+		if _, has := findString(keys, k); has && !listed[k] {
+			ordered = append(ordered, k)
+			listed[k] = true
+		}
+	}
+	for _, k := range keys {
+		if !listed[k] {
+			ordered = append(ordered, k)
+		}
+	}
+	return ordered
+}
+
+// findString returns the index of s in the sorted slice a, and whether it is
+// there.
+func findString(a []string, s string) (int, bool) {
+	i := sort.SearchStrings(a, s)
+	return i, i < len(a) && a[i] == s
+}
+
+// booleanAttrs are the boolean attributes of html, which an empty value turns
+// on just as well as their own name does.
+var booleanAttrs = map[string]bool{
+	"allowfullscreen": true, "async": true, "autofocus": true,
+	"autoplay": true, "checked": true, "controls": true, "default": true,
+	"defer": true, "disabled": true, "formnovalidate": true, "hidden": true,
+	"inert": true, "ismap": true, "itemscope": true, "loop": true,
+	"multiple": true, "muted": true, "nomodule": true, "novalidate": true,
+	"open": true, "playsinline": true, "readonly": true, "required": true,
+	"reversed": true, "selected": true,
+}
+
+// minimizable reports whether attribute key with value v can be written as
+// just key: key is a boolean attribute, and v is empty or repeats it.
+func minimizable(key, v string) bool {
+	return booleanAttrs[strings.ToLower(key)] && (v == "" || strings.EqualFold(key, v))
+}
+
+// singleQuoteEscaper is htmlEscape for attribute values in single quotes,
+// which leaves double quotes alone.
+var singleQuoteEscaper = strings.NewReplacer(
+	"<", "&lt;", ">", "&gt;", "&", "&amp;", "'", "&apos;")
+
+func (opts *Options) quote() string {
+	if opts.Quote == '\'' {
+		return "'"
+	}
+	return "\""
+}
+
+func (opts *Options) quoteAttr(v string) string {
+	if opts.Quote == '\'' {
+		return "'" + singleQuoteEscaper.Replace(v) + "'"
+	}
+	return "\"" + htmlEscape(v) + "\""
+}
+
 func (t *Node) String() string {
+	return t.Format(Options{})
+}
+
+// XML serializes t by the rules of XML rather than html, so that htl can author
+// RSS feeds, SVG images and the like: every element without children
+// closes itself, as <guid/>, whatever its tag, and _ is just an underscore,
+// not a non-breaking space.  Text and attribute values are escaped with the
+// five entities XML defines, and attributes are sorted by name.  Raw nodes
+// are written verbatim.  No XML declaration is added; capture one with
+// ParseOptions.CapturePrologue.
+func (t *Node) XML() string {
+	return t.Format(Options{AttrOrder: map[string][]string{}, xml: true})
+}
+
+// Canonical serializes t to html like String, but with the attributes of
+// every element sorted by name, whatever DefaultAttrOrder says, so that the
+// same tree always comes out byte for byte the same: in reproducible builds,
+// or to compare trees by their html.  Attributes are never written out in the
+// order Go iterates over the map holding them.
+func (t *Node) Canonical() string {
+	return t.Format(Options{AttrOrder: map[string][]string{}})
+}
+
+// Format serializes t to html according to opts.
+func (t *Node) Format(opts Options) string {
+	var b strings.Builder
+	t.writeTo(&b, &opts)
+	return b.String()
+}
+
+// WriteTo writes the html serialization of t, the same String returns, to w
+// as it goes, without holding all of it in memory.  It implements
+// io.WriterTo.
+func (t *Node) WriteTo(w io.Writer) (int64, error) {
+	return t.FormatTo(w, Options{})
+}
+
+// FormatTo is WriteTo, serializing t according to opts, as Format does.
+func (t *Node) FormatTo(w io.Writer, opts Options) (int64, error) {
+	cw := &countingWriter{w: w}
+	b := bufio.NewWriter(cw)
+	t.writeTo(b, &opts)
+	err := b.Flush()
+	return cw.n, err
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// writeTo appends the serialized form of t to b.  Errors are left for b to
+// keep, as strings.Builder and bufio.Writer do.
+func (t *Node) writeTo(b io.StringWriter, opts *Options) {
 	if t == nil {
-		return ""
+		return
 	}
 
 	if t.kind == TextNode {
-		switch t.tag {
-		case "_":
-			return "&nbsp;"
+		opts.started = true
+		switch {
+		case t.tag == "_" && !opts.xml:
+			b.WriteString("&nbsp;")
 		default:
-			return t.tag
+			b.WriteString(htmlEscape(t.tag))
 		}
+		return
+	}
+
+	if t.kind == RawNode {
+		opts.started = true
+		b.WriteString(t.tag)
+		return
 	}
 
-	if t.kind == ElementNode && t.tag == "" {
-		s := ""
+	if t.kind == ElementNode && (t.tag == "" || t.tag == rawTag || t.tag == fragmentTag) {
 		for _, c := range t.content {
-			s += c.String()
+			c.writeTo(b, opts)
 		}
-		return s
+		return
+	}
+
+	if render := opts.Renderers[t.tag]; render != nil && t.kind == ElementNode {
+		opts.started = true
+		b.WriteString(render(t))
+		return
 	}
 
 	if t.kind == ElementNode {
-		s := "<" + t.tag
+		indented := opts.indents(t)
+		if indented && opts.started {
+			b.WriteString("\n" + strings.Repeat(opts.IndentBlocks, opts.depth))
+		}
+		b.WriteString("<" + t.tag)
+		opts.started = true
 		attrKeys := []string{}
 		for k, _ := range t.attr {
 			attrKeys = append(attrKeys, k)
 		}
 		sort.Sort(stringSlice(attrKeys))
-		for _, k := range attrKeys {
-			s += " " + k + "=\"" + t.attr[k] + "\""
+		for _, k := range opts.orderAttrs(t.tag, attrKeys) {
+			if opts.OmitEmptyAttrs && t.attr[k] == "" {
+				continue
+			}
+			if opts.MinimizeBooleanAttrs && minimizable(k, t.attr[k]) {
+				b.WriteString(" " + k)
+				continue
+			}
+			if t.rawAttr[k] {
+				b.WriteString(" " + k + "=" + opts.quote() + t.attr[k] + opts.quote())
+				continue
+			}
+			b.WriteString(" " + k + "=" + opts.quoteAttr(t.attr[k]))
 		}
 		if len(t.content) == 0 {
-			if _, isDegenerate := degenerateTags[t.tag]; isDegenerate {
-				s += "/>"
+			if _, isDegenerate := degenerateTags[t.tag]; isDegenerate || opts.xml {
+				b.WriteString("/>")
 			} else {
-				s += "></" + t.tag + ">"
+				b.WriteString("></" + t.tag + ">")
 			}
 		} else {
-			s += ">"
+			b.WriteString(">")
+			if indented && t.tag != "pre" {
+				opts.depth++
+			} else {
+				opts.flat++
+			}
 			for _, c := range t.content {
-				s += c.String()
+				c.writeTo(b, opts)
 			}
-			s += "</" + t.tag + ">"
+			if indented && t.tag != "pre" {
+				opts.depth--
+				if opts.indentsChild(t) {
+					b.WriteString("\n" + strings.Repeat(opts.IndentBlocks, opts.depth))
+				}
+			} else {
+				opts.flat--
+			}
+			b.WriteString("</" + t.tag + ">")
 		}
-		return s
 	}
+}
+
+// indents reports whether element t is written on a line of its own.
+func (opts *Options) indents(t *Node) bool {
+	return opts.IndentBlocks != "" && opts.flat == 0 && blockTags[t.tag]
+}
 
-	return ""
+// indentsChild reports whether an element in the content of t, or of the
+// fragments in it, is written on a line of its own.
+func (opts *Options) indentsChild(t *Node) bool {
+	for _, c := range t.content {
+		if c.kind != ElementNode {
+			continue
+		}
+		if c.tag == "" || c.tag == rawTag || c.tag == fragmentTag {
+			if opts.indentsChild(c) {
+				return true
+			}
+		} else if opts.indents(c) {
+			return true
+		}
+	}
+	return false
 }
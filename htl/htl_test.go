@@ -1,45 +1,118 @@
 package htl
 
 import (
+	"bytes"
+	"errors"
+	"strings"
 	"testing"
 )
 
+// parseCases pairs inputs with the html they parse to, or "" for an error.
+var parseCases = []struct{ in, want string }{
+	{"",
+		""},
+	{"(a :href http://foo.bar/{{user}} \"안녕\")",
+		"<a href=\"http://foo.bar/{{user}}\">안녕</a>"},
+	{"(a :href foo)",
+		"<a href=\"foo\"></a>"},
+	{"(br)",
+		"<br/>"},
+	{"(a :href foo )",
+		"<a href=\"foo\"></a>"},
+	{"(a b)",
+		"<a>b</a>"},
+	{"(a b(c d))",
+		"<a>b<c>d</c></a>"},
+	{"(a (b (c)))",
+		"<a><b><c></c></b></a>"},
+	{"(a(b(c)))",
+		"<a><b><c></c></b></a>"},
+	{"(a(b(c))", // missing a closing bracket.
+		""},
+	{"(a(b(c))))", // extra closing bracket.
+		""},
+	{"(a \"x\\ty\\nz\")",
+		"<a>x\ty\nz</a>"},
+	{"(a :x 1 (b :z 2 :y 3 (c \"foo bar\" \"baz\")))",
+		"<a x=\"1\"><b y=\"3\" z=\"2\"><c>foo barbaz</c></b></a>"},
+	{"(a :x \"\\\\<>'\\\"\" \"content\")",
+		"<a x=\"\\&lt;&gt;&apos;&quot;\">content</a>"},
+	{"(a ;comments\n :x ;comments\n\"\\\\<>'\\\"\" ;comments \n\"content\")",
+		"<a x=\"\\&lt;&gt;&apos;&quot;\">content</a>"},
+	{"(a \"b\" ; \"c\"\n ;; \"d\"\n)",
+		"<a>b</a>"},
+	{"(pre \"  a\\n\\t b  \n  c \r\n\")", // quoted strings keep all whitespace.
+		"<pre>  a\n\t b  \n  c \r\n</pre>"},
+	{"(pre \"a\"  \"  b\")", // whitespace between tokens is dropped,
+		"<pre>a  b</pre>"},
+	{"(pre a   b\n c)", // even between symbols.
+		"<pre>abc</pre>"},
+	{"(div#main.a.b.c \"x\")",
+		"<div class=\"a b c\" id=\"main\">x</div>"},
+	{"(.a (span.b#c))", // the tag defaults to div.
+		"<div class=\"a\"><span class=\"b\" id=\"c\"></span></div>"},
+	{"(p.a :class \"b c\")", // an explicit class adds to the shorthand.
+		"<p class=\"a b c\"></p>"},
+	{"(p :class a :class b)", // without shorthand, the last class wins.
+		"<p class=\"b\"></p>"},
+	{"(p#a :id b)", // an id both ways is an error.
+		""},
+	{"(p#a#b)",
+		""},
+	{"(p.a..b)",
+		""},
+	{"(p (raw \"<custom>&stuff;</custom>\" \"\\\"\\\\\"))", // no escaping in raw.
+		"<p><custom>&stuff;</custom>\"\\</p>"},
+	{"(p \"<custom>&stuff;</custom>\")", // unlike in other strings.
+		"<p>&lt;custom&gt;&amp;stuff;&lt;/custom&gt;</p>"},
+	{"(raw (b))",
+		""},
+	{"(a) b", // trailing symbol without a newline.
+		"<a></a>b"},
+	{"(a) ; comment",
+		"<a></a>"},
+	{"(a) \"b", // unterminated string.
+		""},
+	{"(a) \"b\\", // unterminated escape.
+		""},
+	{"b :x 1", // attribute outside of any element.
+		""},
+	{"(a \"text\" :href foo)", // attributes may follow content,
+		"<a href=\"foo\">text</a>"},
+	{"(p x :id a (b y) :class c \"z\" :title t)", // anywhere in it.
+		"<p class=\"c\" id=\"a\" title=\"t\">x<b>y</b>z</p>"},
+	{"(p (b) :class c :class d)", // the last one wins, as before content.
+		"<p class=\"d\"><b></b></p>"},
+	{"(p (b) :)",
+		""},
+	{"(fragment (li a) \"b\" (li c))", // a fragment has no element of its own,
+		"<li>a</li>b<li>c</li>"},
+	{"(ul (<> (li a) (li b)) (li c))", // nor does its short form.
+		"<ul><li>a</li><li>b</li><li>c</li></ul>"},
+	{"(fragment)",
+		""},
+	{"(fragment :id x (p))",
+		""},
+	{"(fragment.x (p))",
+		""},
+	{"(a :href \"/x\" \"Click\")", // a string after a value is content.
+		"<a href=\"/x\">Click</a>"},
+	{"(svg (use :xlink:href #a))", // a colon within a symbol is no keyword,
+		"<svg><use xlink:href=\"#a\"></use></svg>"},
+	{"(a :href mailto:me@example.com a:b)",
+		"<a href=\"mailto:me@example.com\">a:b</a>"},
+	{"(p :data-sel \":root\" \"x\")", // one starting a value is quoted,
+		"<p data-sel=\":root\">x</p>"},
+	{"(p :data-sel \\:root \\:a:b)", // or escaped.
+		"<p data-sel=\":root\">:a:b</p>"},
+	{"(p :data-sel :root)",
+		""},
+	{"(p \\x)",
+		""},
+}
+
 func TestParse(t *testing.T) {
-	cases := []struct{ in, want string }{
-		{"",
-			""},
-		{"(a :href http://foo.bar/{{user}} \"안녕\")",
-			"<a href=\"http://foo.bar/{{user}}\">안녕</a>"},
-		{"(a :href foo)",
-			"<a href=\"foo\"></a>"},
-		{"(br)",
-			"<br/>"},
-		{"(a :href foo )",
-			"<a href=\"foo\"></a>"},
-		{"(a b)",
-			"<a>b</a>"},
-		{"(a b(c d))",
-			"<a>b<c>d</c></a>"},
-		{"(a (b (c)))",
-			"<a><b><c></c></b></a>"},
-		{"(a(b(c)))",
-			"<a><b><c></c></b></a>"},
-		{"(a(b(c))", // missing a closing bracket.
-			""},
-		{"(a(b(c))))", // extra closing bracket.
-			""},
-		{"(a \"x\\ty\\nz\")",
-			"<a>x\ty\nz</a>"},
-		{"(a :x 1 (b :z 2 :y 3 (c \"foo bar\" \"baz\")))",
-			"<a x=\"1\"><b y=\"3\" z=\"2\"><c>foo barbaz</c></b></a>"},
-		{"(a :x \"\\\\<>'\\\"\" \"content\")",
-			"<a x=\"\\&lt;&gt;&apos;&quot;\">content</a>"},
-		{"(a ;comments\n :x ;comments\n\"\\\\<>'\\\"\" ;comments \n\"content\")",
-			"<a x=\"\\&lt;&gt;&apos;&quot;\">content</a>"},
-		{"(a \"b\" ; \"c\"\n ;; \"d\"\n)",
-			"<a>b</a>"},
-	}
-	for _, c := range cases {
+	for _, c := range parseCases {
 		parsedTree, _ := Parse(c.in)
 		if got := parsedTree.String(); got != c.want {
 			t.Errorf("Parse(x).String():\ninput: %q\n  got: %q\n want: %q",
@@ -47,3 +120,692 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+func TestParseByteOrderMark(t *testing.T) {
+	for _, parse := range []func(string) (*Node, error){
+		Parse,
+		func(s string) (*Node, error) { return ParseReader(strings.NewReader(s)) },
+	} {
+		tree, err := parse("\uFEFF(a b)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := tree.String(), "<a>b</a>"; got != want {
+			t.Errorf("got: %q\nwant: %q", got, want)
+		}
+		if tree, err := parse("\uFEFF"); tree != nil || err != nil {
+			t.Errorf("parse of a lone byte order mark = %v, %v; want nil, nil as for empty input", tree, err)
+		}
+	}
+}
+
+func TestParseInvalidUTF8(t *testing.T) {
+	in := "(a\n \"b\xffc\")"
+	tree, err := Parse(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tree.String(), "<a>b\uFFFDc</a>"; got != want {
+		t.Errorf("got: %q\nwant: %q", got, want)
+	}
+
+	_, err = ParseWithOptions(in, ParseOptions{RejectInvalidUTF8: true})
+	if err == nil {
+		t.Fatalf("ParseWithOptions(%q) succeeded, want an error", in)
+	}
+	if want := "line 2 column 4"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not mention %q", err, want)
+	}
+}
+
+func TestParseStoresText(t *testing.T) {
+	tree, err := Parse("(a :title \"x<y\" :alt a&amp;b#c \"\\\"<&>'\" &copy;)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := tree.content[0]
+	if got, want := a.content[0].tag, "\"<&>'"; got != want {
+		t.Errorf("text node holds %q, want %q", got, want)
+	}
+	if got, want := a.content[1].tag, "\u00a9"; got != want {
+		t.Errorf("symbol text node holds %q, want %q", got, want)
+	}
+	if got, want := a.attr["title"], "x<y"; got != want {
+		t.Errorf("title holds %q, want %q", got, want)
+	}
+	if got, want := a.attr["alt"], "a&amp;b#c"; got != want {
+		t.Errorf("symbol alt holds %q, want %q", got, want)
+	}
+	if got, want := tree.String(), "<a alt=\"a&amp;amp;b#c\" title=\"x&lt;y\">&quot;&lt;&amp;&gt;&apos;\u00a9</a>"; got != want {
+		t.Errorf("got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestParseSymbolEntities(t *testing.T) {
+	// Character references ending in a semicolon in text symbols are decoded
+	// when parsed, and the text escaped again only where html needs it, so
+	// entities other than those of markup characters come out as the
+	// characters they name.  Everything else is taken as it reads.
+	cases := []struct{ in, want string }{
+		{"(p &copy; &nbsp; a<b)", "<p>\u00a9\u00a0a&lt;b</p>"},
+		{"(p &amp;&lt;&gt; &#169;&#xA9;)", "<p>&amp;&lt;&gt;\u00a9\u00a9</p>"},
+		{"(p &bogus; &copy &copyx; &#12x; a&)", "<p>&amp;bogus;&amp;copy&amp;copyx;&amp;#12x;a&amp;</p>"},
+		{"(p \"&copy;\")", "<p>&amp;copy;</p>"}, // quoted strings are not decoded,
+		{"(a :href /x?a=1&copy=2&not=3)", // nor are attribute values,
+			"<a href=\"/x?a=1&amp;copy=2&amp;not=3\"></a>"},
+		{"(p :title &quot;x&quot;)", "<p title=\"&amp;quot;x&amp;quot;\"></p>"},
+		{"(p#a&amp;b)", "<p id=\"a&amp;amp;b\"></p>"}, // even in the shorthand.
+	}
+	for _, c := range cases {
+		tree, err := Parse(c.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.in, err)
+		}
+		if got := tree.String(); got != c.want {
+			t.Errorf("Parse(%q).String():\n  got: %q\n want: %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseStrictEscapes(t *testing.T) {
+	in := "(a :title \"x\\ty\"\n \"\\\"\\q\\\\\")"
+	tree, err := Parse(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tree.String(), "<a title=\"x\ty\">&quot;q\\</a>"; got != want {
+		t.Errorf("got: %q\nwant: %q", got, want)
+	}
+
+	_, err = ParseWithOptions(in, ParseOptions{StrictEscapes: true})
+	want := ParseError{Line: 2, Column: 6, Rune: 'q', Msg: "unknown escape sequence \\q"}
+	if pe, ok := err.(*ParseError); !ok || *pe != want {
+		t.Errorf("ParseWithOptions(%q) error = %#v, want %+v", in, err, want)
+	}
+
+	for _, in := range []string{"(a \"\\f\\n\\r\\t\\v\\\\\\\"\")", "(raw \"\\\"\")"} {
+		if _, err := ParseWithOptions(in, ParseOptions{StrictEscapes: true}); err != nil {
+			t.Errorf("ParseWithOptions(%q) strict: %v", in, err)
+		}
+	}
+}
+
+func TestParseCommentRune(t *testing.T) {
+	in := "(p ;a comment\n x;y #b\n)"
+	cases := []struct {
+		opts ParseOptions
+		want string
+	}{
+		{ParseOptions{}, "<p>x;y#b</p>"},
+		{ParseOptions{CommentRune: '#'}, "<p>;acommentx;y</p>"},
+		{ParseOptions{NoComments: true}, "<p>;acommentx;y#b</p>"},
+		{ParseOptions{NoComments: true, CommentRune: '#'}, "<p>;acommentx;y#b</p>"},
+	}
+	for _, c := range cases {
+		tree, err := ParseWithOptions(in, c.opts)
+		if err != nil {
+			t.Errorf("ParseWithOptions(%q, %+v): %v", in, c.opts, err)
+			continue
+		}
+		if got := tree.String(); got != c.want {
+			t.Errorf("ParseWithOptions(%q, %+v):\n  got: %q\n want: %q", in, c.opts, got, c.want)
+		}
+	}
+
+	for _, r := range []rune{'(', '"', ':', ' ', '\n'} {
+		if _, err := ParseWithOptions("(p)", ParseOptions{CommentRune: r}); err == nil {
+			t.Errorf("ParseWithOptions with CommentRune %q succeeded, want an error", r)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	cases := []struct {
+		in   string
+		want ParseError
+	}{
+		{"(a\n  :x (b))",
+			ParseError{Line: 2, Column: 8, Rune: ')', Msg: "unknown attribute value form \"b\""}},
+		{"(a\n  :x)",
+			ParseError{Line: 2, Column: 5, Rune: ')', Msg: "unexpected close paren"}},
+		{"(a\n (b)",
+			ParseError{Line: 2, Column: 4, Rune: EndOfInput,
+				Msg: "parser stack contains more than the root element.  " +
+					"Perhaps 1 closing parens are missing"}},
+		{"(a \"b)",
+			ParseError{Line: 1, Column: 6, Rune: EndOfInput, Msg: "unterminated string"}},
+	}
+	for _, c := range cases {
+		_, err := Parse(c.in)
+		pe, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("Parse(%q) error = %#v, want a *ParseError", c.in, err)
+			continue
+		}
+		if *pe != c.want {
+			t.Errorf("Parse(%q) error = %+v, want %+v", c.in, *pe, c.want)
+		}
+	}
+
+	_, err := Parse("(a b))")
+	if got, want := err.Error(), "Error processing rune ')' (line 1 column 6).  unexpected closing paren."; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestParseAttrOptions(t *testing.T) {
+	cases := []struct {
+		in   string
+		opts ParseOptions
+		want string // "" for an error.
+	}{
+		{"(div :class a :class b)", ParseOptions{},
+			"<div class=\"b\"></div>"},
+		{"(div :class a :class b :style color:red; :style margin:0 :id x :id y)",
+			ParseOptions{DuplicateAttrs: DuplicateAttrsMerge},
+			"<div class=\"a b\" id=\"y\" style=\"color:red;margin:0\"></div>"},
+		{"(div :class a :class b)", ParseOptions{DuplicateAttrs: DuplicateAttrsError},
+			""},
+		{"(div.a :class b)", ParseOptions{DuplicateAttrs: DuplicateAttrsError},
+			"<div class=\"a b\"></div>"},
+		{"(div :Class a :class b)", ParseOptions{DuplicateAttrs: DuplicateAttrsMerge},
+			"<div Class=\"a\" class=\"b\"></div>"},
+		{"(div :Class a :class b)",
+			ParseOptions{DuplicateAttrs: DuplicateAttrsMerge, LowercaseAttrs: true},
+			"<div class=\"a b\"></div>"},
+		{"(svg :viewBox \"0 0 1 1\" (use :xlink:HREF #a :onClick f))",
+			ParseOptions{LowercaseAttrs: true},
+			"<svg viewBox=\"0 0 1 1\"><use onClick=\"f\" xlink:HREF=\"#a\"></use></svg>"},
+		{"(a :HREF x :xlink:HREF y)", ParseOptions{LowercaseAttrs: true},
+			"<a href=\"x\" xlink:HREF=\"y\"></a>"},
+		{"(div :class \"btn \" \"btn-primary \"\n  \"large\" \"body\")", // adjacent strings join,
+			ParseOptions{JoinAttrStrings: true},
+			"<div class=\"btn btn-primary largebody\"></div>"},
+		{"(div :class \"a \" ; comment\n \"b\" body \"c\")", // up to any other token.
+			ParseOptions{JoinAttrStrings: true},
+			"<div class=\"a b\">bodyc</div>"},
+		{"(div :class a \"b\")", ParseOptions{JoinAttrStrings: true}, // a symbol value takes no strings,
+			"<div class=\"a\">b</div>"},
+		{"(div :class \"a\" (b) \"c\")", ParseOptions{JoinAttrStrings: true}, // and an element ends the value.
+			"<div class=\"a\"><b></b>c</div>"},
+		{"(div :class \"a\" :id \"b\" \"c\" :title t)", ParseOptions{JoinAttrStrings: true},
+			"<div class=\"a\" id=\"bc\" title=\"t\"></div>"},
+		{"(div.x :class \"a\" \"b\")", ParseOptions{JoinAttrStrings: true},
+			"<div class=\"x ab\"></div>"},
+		{"(div.x :class \"a\" \"b\")", ParseOptions{},
+			"<div class=\"x a\">b</div>"},
+	}
+	for _, c := range cases {
+		tree, err := ParseWithOptions(c.in, c.opts)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("ParseWithOptions(%q, %+v) succeeded, want an error", c.in, c.opts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseWithOptions(%q, %+v): %v", c.in, c.opts, err)
+			continue
+		}
+		if got := tree.String(); got != c.want {
+			t.Errorf("ParseWithOptions(%q, %+v):\n  got: %q\n want: %q", c.in, c.opts, got, c.want)
+		}
+	}
+}
+
+func TestParseAttrForms(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string // "" for an error.
+	}{
+		{"(div :style (css :color red :margin 0) \"x\")",
+			"<div style=\"color:red;margin:0\">x</div>"},
+		{"(div :style (css :z-index 2 :font-family \"a b\") :id y (p))",
+			"<div id=\"y\" style=\"z-index:2;font-family:a b\"><p></p></div>"},
+		{"(div :style (css))",
+			"<div style=\"\"></div>"},
+		{"(div :style (nope :color red))", ""},
+		{"(div :style (css :color red (p)))", ""},
+		{"(div :style (css \"color: red\"))", ""},
+		{"(div :style (css :color red)", ""},
+		{"(div :data-config \"{\\\"a\\\": 1}\")",
+			"<div data-config=\"{&quot;a&quot;: 1}\"></div>"},
+		{"(img :srcset (raw \"a.png 1x, b.png 2x\") :alt \"a&b\")",
+			"<img alt=\"a&amp;b\" srcset=\"a.png 1x, b.png 2x\"/>"},
+		{"(div :data-config (raw \"{'a': \" \"1}\") :title \"it's\")",
+			"<div data-config=\"{'a': 1}\" title=\"it&apos;s\"></div>"},
+		{"(div :x (raw :a b))", ""},
+		{"(div :x (raw (b)))", ""},
+	}
+	for _, c := range cases {
+		tree, err := Parse(c.in)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("Parse(%q) = %q, want an error", c.in, tree)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.in, err)
+			continue
+		}
+		if got := tree.String(); got != c.want {
+			t.Errorf("Parse(%q):\n  got: %q\n want: %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	root, err := ParseAll("(h1 Title)\n(p one)", "", "; partial\n(p two) three")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := root.String(), "<h1>Title</h1><p>one</p><p>two</p>three"; got != want {
+		t.Errorf("ParseAll:\n  got: %q\n want: %q", got, want)
+	}
+
+	_, err = ParseAll("(p one)", "(p two)\n  (p three))")
+	var se *SourceError
+	if !errors.As(err, &se) || se.Index != 1 {
+		t.Fatalf("ParseAll with an error in the second source = %v, want a *SourceError for source 1", err)
+	}
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Line != 2 || pe.Column != 12 {
+		t.Errorf("ParseAll error = %v, want a *ParseError at 2:12", err)
+	}
+	if !strings.HasPrefix(err.Error(), "source 1: ") {
+		t.Errorf("ParseAll error = %q, want it to name source 1", err.Error())
+	}
+}
+
+func TestParseLenient(t *testing.T) {
+	cases := []struct {
+		in       string
+		want     string
+		warnings []ParseError
+	}{
+		{"(a(b(c))", "<a><b><c></c></b></a>",
+			[]ParseError{{Line: 1, Column: 8, Rune: EndOfInput, Msg: "closing 1 elements left open"}}},
+		{"(a(b(c))))\n(d)", "<a><b><c></c></b></a><d></d>",
+			[]ParseError{{Line: 1, Column: 10, Rune: ')', Msg: "ignoring unexpected closing paren"}}},
+		{"(a b)", "<a>b</a>", nil},
+	}
+	for _, c := range cases {
+		tree, warnings := ParseLenient(c.in)
+		if got := tree.String(); got != c.want {
+			t.Errorf("ParseLenient(%q):\n  got: %q\n want: %q", c.in, got, c.want)
+		}
+		if len(warnings) != len(c.warnings) {
+			t.Errorf("ParseLenient(%q) warnings = %v, want %v", c.in, warnings, c.warnings)
+			continue
+		}
+		for i, w := range warnings {
+			if pe, ok := w.(*ParseError); !ok || *pe != c.warnings[i] {
+				t.Errorf("ParseLenient(%q) warning %d = %#v, want %+v", c.in, i, w, c.warnings[i])
+			}
+		}
+	}
+
+	tree, errs := ParseLenient("(a :x (b)))")
+	if tree != nil || len(errs) != 1 {
+		t.Errorf("ParseLenient of a hard error = %v, %v; want nil and the error", tree, errs)
+	}
+	if _, err := Parse("(a(b(c))"); err == nil {
+		t.Errorf("Parse became lenient")
+	}
+}
+
+func TestParsePrologue(t *testing.T) {
+	capture := ParseOptions{CapturePrologue: true}
+	cases := []struct {
+		in      string
+		opts    ParseOptions
+		want    string
+		wantErr ParseError
+	}{
+		{"<!DOCTYPE html>\n(html (body))", capture,
+			"<!DOCTYPE html><html><body></body></html>", ParseError{}},
+		{"\ufeff <?xml version=\"1.0\"?>\n<!DOCTYPE rss>\n(rss)", capture,
+			"<?xml version=\"1.0\"?><!DOCTYPE rss><rss></rss>", ParseError{}},
+		{"<!DOCTYPE html>\n(html)", ParseOptions{},
+			"", ParseError{Line: 1, Column: 1, Rune: '<',
+				Msg: "HTL uses S-expression syntax, like (p \"text\"), not html tags; " +
+					"did you mean to write an HTL document?"}},
+		{"\n  <html>(p)", capture,
+			"", ParseError{Line: 2, Column: 3, Rune: '<',
+				Msg: "HTL uses S-expression syntax, like (p \"text\"), not html tags; " +
+					"did you mean to write an HTL document?"}},
+		{"<!DOCTYPE html\n(html)", capture,
+			"", ParseError{Line: 1, Column: 1, Rune: '<',
+				Msg: "unterminated doctype or processing instruction"}},
+		{"<!DOCTYPE html>\n(p\n  (b)))", capture,
+			"", ParseError{Line: 3, Column: 7, Rune: ')', Msg: "unexpected closing paren"}},
+	}
+	for _, c := range cases {
+		tree, err := ParseWithOptions(c.in, c.opts)
+		if c.want != "" {
+			if err != nil {
+				t.Errorf("ParseWithOptions(%q): %v", c.in, err)
+			} else if got := tree.String(); got != c.want {
+				t.Errorf("ParseWithOptions(%q):\n  got: %q\n want: %q", c.in, got, c.want)
+			}
+			continue
+		}
+		if pe, ok := err.(*ParseError); !ok || *pe != c.wantErr {
+			t.Errorf("ParseWithOptions(%q) error = %#v, want %+v", c.in, err, c.wantErr)
+		}
+	}
+}
+
+// FuzzParse checks that Parse returns either a tree or an error, and that
+// neither it nor ParseLenient panics.
+func FuzzParse(f *testing.F) {
+	for _, s := range []string{
+		"", "(a :href http://foo \"body\")", "(a(b(c))", "(a \"x\\ty\")",
+		"(a ;c\n :x \"v\")", "\"", "(a :", "a b", byteOrderMark,
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, in string) {
+		tree, err := Parse(in)
+		if (err == nil) == (tree == nil) && strings.TrimPrefix(in, byteOrderMark) != "" {
+			t.Fatalf("Parse(%q) = %v, %v; want exactly one of them", in, tree, err)
+		}
+		_ = tree.String()
+		tree, _ = ParseLenient(in)
+		_ = tree.String()
+
+		tree, spans, _ := ParseWithSpans(in)
+		for n, span := range spans {
+			if span[0] < 0 || span[0] > span[1] || span[1] > len(in) {
+				t.Fatalf("ParseWithSpans(%q): span %v of %q is out of range", in, span, n)
+			}
+			if n != tree && n.kind == ElementNode && (in[span[0]] != '(' || in[span[1]-1] != ')') {
+				t.Fatalf("ParseWithSpans(%q): span of %q is %q", in, n, in[span[0]:span[1]])
+			}
+		}
+	})
+}
+
+func TestAppend(t *testing.T) {
+	n := Element("ul", Element("li", Text("a"))).Append(Element("li", Text("b")), Text("c"))
+	if got, want := n.String(), "<ul><li>a</li><li>b</li>c</ul>"; got != want {
+		t.Errorf("Append: got %q, want %q", got, want)
+	}
+}
+
+func TestXML(t *testing.T) {
+	in := `<?xml version="1.0" encoding="UTF-8"?>
+(rss :version 2.0
+  (channel
+    (title "Tom & Jerry's")
+    (link https://example.com/)
+    (item
+      (title "<b>New</b> episode")
+      (guid :isPermaLink false ep_1)
+      (category)
+      (description _))
+    (atom:link :href https://example.com/feed.xml :rel self (raw "<!-- raw -->"))
+    (meta :name x)))`
+	root, err := ParseWithOptions(in, ParseOptions{CapturePrologue: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<rss version="2.0"><channel>` +
+		`<title>Tom &amp; Jerry&apos;s</title>` +
+		`<link>https://example.com/</link>` +
+		`<item><title>&lt;b&gt;New&lt;/b&gt; episode</title>` +
+		`<guid isPermaLink="false">ep_1</guid>` +
+		`<category/>` +
+		`<description>_</description></item>` +
+		`<atom:link href="https://example.com/feed.xml" rel="self"><!-- raw --></atom:link>` +
+		`<meta name="x"/>` +
+		`</channel></rss>`
+	if got := root.XML(); got != want {
+		t.Errorf("XML():\n  got: %s\n want: %s", got, want)
+	}
+	if got, want := root.ChildAt(1, 0, 2, 3).String(), "<description>&nbsp;</description>"; got != want {
+		t.Errorf("String() of _ = %q, want %q: XML must not change html", got, want)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	keys := []string{"name", "content", "charset", "data-z", "data-a", "id", "class", "http-equiv"}
+	forward, backward := Element("meta"), Element("meta")
+	for i, k := range keys {
+		forward.SetAttr(k, k)
+		backward.SetAttr(keys[len(keys)-1-i], keys[len(keys)-1-i])
+	}
+	tree := Element("head", forward, Text("<&>"))
+	want := "<head><meta charset=\"charset\" class=\"class\" content=\"content\" data-a=\"data-a\" " +
+		"data-z=\"data-z\" http-equiv=\"http-equiv\" id=\"id\" name=\"name\"/>&lt;&amp;&gt;</head>"
+	for i := 0; i < 100; i++ {
+		if got := tree.Canonical(); got != want {
+			t.Fatalf("Canonical() #%d:\n  got: %q\n want: %q", i, got, want)
+		}
+	}
+	if got, want := backward.Canonical(), forward.Canonical(); got != want {
+		t.Errorf("Canonical() depends on the order attributes were set:\n  got: %q\n want: %q", got, want)
+	}
+}
+
+func TestFormatQuote(t *testing.T) {
+	cases := []struct {
+		in    string
+		quote rune
+		want  string
+	}{
+		{"(div :data-x \"{\\\"a\\\": 1}\")", 0,
+			"<div data-x=\"{&quot;a&quot;: 1}\"></div>"},
+		{"(div :data-x \"{\\\"a\\\": 1}\")", '"',
+			"<div data-x=\"{&quot;a&quot;: 1}\"></div>"},
+		{"(div :data-x \"{\\\"a\\\": 1}\")", '\'',
+			"<div data-x='{\"a\": 1}'></div>"},
+		{"(div :title \"it's\")", '\'',
+			"<div title='it&apos;s'></div>"},
+		{"(div :title it's)", '\'',
+			"<div title='it&apos;s'></div>"},
+	}
+	for _, c := range cases {
+		parsedTree, err := Parse(c.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.in, err)
+		}
+		if got := parsedTree.Format(Options{Quote: c.quote}); got != c.want {
+			t.Errorf("Parse(x).Format(%q):\ninput: %q\n  got: %q\n want: %q",
+				c.quote, c.in, got, c.want)
+		}
+	}
+}
+
+func TestFormatMinimizeBooleanAttrs(t *testing.T) {
+	in := "(form (input :type checkbox :checked checked :disabled \"\" :title \"\" :value value) " +
+		"(input :value value :title title :name name) (option :selected SELECTED))"
+	cases := []struct {
+		minimize bool
+		want     string
+	}{
+		{false, "<form><input type=\"checkbox\" checked=\"checked\" disabled=\"\" title=\"\" value=\"value\"></input>" +
+			"<input name=\"name\" title=\"title\" value=\"value\"></input>" +
+			"<option selected=\"SELECTED\"></option></form>"},
+		{true, "<form><input type=\"checkbox\" checked disabled title=\"\" value=\"value\"></input>" +
+			"<input name=\"name\" title=\"title\" value=\"value\"></input>" +
+			"<option selected></option></form>"},
+	}
+	tree, err := Parse(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		if got := tree.Format(Options{MinimizeBooleanAttrs: c.minimize}); got != c.want {
+			t.Errorf("Format(MinimizeBooleanAttrs: %v):\n  got: %q\n want: %q", c.minimize, got, c.want)
+		}
+	}
+}
+
+// failingWriter accepts n bytes, then fails.
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errors.New("disk full")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestFormatAttrOrder(t *testing.T) {
+	tree, err := Parse("(head (meta :content \"text/html\" :http-equiv content-type) " +
+		"(meta :name viewport :content x) (meta :content y :charset utf-8))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		order map[string][]string
+		want  string
+	}{
+		{nil, "<head><meta http-equiv=\"content-type\" content=\"text/html\"/>" +
+			"<meta name=\"viewport\" content=\"x\"/><meta charset=\"utf-8\" content=\"y\"/></head>"},
+		{map[string][]string{}, "<head><meta content=\"text/html\" http-equiv=\"content-type\"/>" +
+			"<meta content=\"x\" name=\"viewport\"/><meta charset=\"utf-8\" content=\"y\"/></head>"},
+		{map[string][]string{"meta": {"content", "missing", "content"}},
+			"<head><meta content=\"text/html\" http-equiv=\"content-type\"/>" +
+				"<meta content=\"x\" name=\"viewport\"/><meta content=\"y\" charset=\"utf-8\"/></head>"},
+	}
+	for _, c := range cases {
+		if got := tree.Format(Options{AttrOrder: c.order}); got != c.want {
+			t.Errorf("Format(AttrOrder: %v):\n  got: %q\n want: %q", c.order, got, c.want)
+		}
+	}
+}
+
+func TestFormatIndentBlocks(t *testing.T) {
+	tree, err := Parse(`(h1 "Hi " (a :href /x link))
+(div
+  (ul (li one) (li (b two) " and " (i three)))
+  (p "a " (span (div x)) (br) b)
+  (pre (div y))
+  (fragment (p z)))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<h1>Hi <a href="/x">link</a></h1>
+<div>
+  <ul>
+    <li>one</li>
+    <li><b>two</b> and <i>three</i></li>
+  </ul>
+  <p>a <span><div>x</div></span><br/>b</p>
+  <pre><div>y</div></pre>
+  <p>z</p>
+</div>`
+	if got := tree.Format(Options{IndentBlocks: "  "}); got != want {
+		t.Errorf("Format(IndentBlocks):\n  got: %s\n want: %s", got, want)
+	}
+	if got, want := Element("span", Element("p", Text("x"))).Format(Options{IndentBlocks: "\t"}),
+		"<span><p>x</p></span>"; got != want {
+		t.Errorf("Format(IndentBlocks) of a block in an inline element = %q, want %q", got, want)
+	}
+}
+
+func TestFormatRenderers(t *testing.T) {
+	tree, err := Parse("(p \"Rate: \" (x-icon :name star) (x-icon :name \"a&b\") (b (x-icon)))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Renderers: map[string]func(*Node) string{
+		"x-icon": func(n *Node) string {
+			name, _ := n.Attr("name")
+			return Element("svg", Element("use").SetAttr("href", "#icon-"+name)).AddClass("icon").String()
+		},
+	}}
+	want := `<p>Rate: <svg class="icon"><use href="#icon-star"></use></svg>` +
+		`<svg class="icon"><use href="#icon-a&amp;b"></use></svg>` +
+		`<b><svg class="icon"><use href="#icon-"></use></svg></b></p>`
+	if got := tree.Format(opts); got != want {
+		t.Errorf("Format(Renderers):\n  got: %q\n want: %q", got, want)
+	}
+	var b bytes.Buffer
+	if n, err := tree.FormatTo(&b, opts); err != nil || b.String() != want || n != int64(len(want)) {
+		t.Errorf("FormatTo(Renderers) = %d, %v, wrote %q; want %d, nil, %q", n, err, b.String(), len(want), want)
+	}
+	if got := tree.String(); !strings.Contains(got, `<x-icon name="star"></x-icon>`) {
+		t.Errorf("String() = %q, want x-icon written out as usual", got)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	for _, c := range parseCases {
+		tree, err := Parse(c.in)
+		if err != nil {
+			continue
+		}
+		var b bytes.Buffer
+		n, err := tree.WriteTo(&b)
+		if err != nil || b.String() != tree.String() || n != int64(b.Len()) {
+			t.Errorf("Parse(%q).WriteTo = %d, %v, wrote %q; want %d, nil, %q",
+				c.in, n, err, b.String(), len(tree.String()), tree.String())
+		}
+	}
+
+	tree := Element("p", Text(strings.Repeat("x", 10000)))
+	n, err := tree.WriteTo(&failingWriter{n: 100})
+	if err == nil || n != 100 {
+		t.Errorf("WriteTo a failing writer = %d, %v; want 100 and its error", n, err)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	tree := Element("ul",
+		Element("li", Element("a", Text("a<b")).SetAttr("href", "a<b")),
+		Element("li", Text("c"), Raw("<br>")).SetRawAttr("data-x", "{'a':1}"),
+		Fragment(Element("li", Text("d")), Element("li", Text("e"))))
+	want := "<ul><li><a href=\"a&lt;b\">a&lt;b</a></li><li data-x=\"{'a':1}\">c<br></li>" +
+		"<li>d</li><li>e</li></ul>"
+	if got := tree.String(); got != want {
+		t.Errorf("got: %q\nwant: %q", got, want)
+	}
+}
+
+// wideTree builds a synthetic tree of roughly n nodes: a root holding rows of
+// elements, each with an attribute, a text child and a degenerate child.
+func wideTree(n int) *Node {
+	root := NewNode(ElementNode, "div")
+	for i := 0; i < n/3; i++ {
+		row := NewNode(ElementNode, "p")
+		row.attr["class"] = "row"
+		row.content = append(row.content, NewNode(TextNode, "text"))
+		row.content = append(row.content, NewNode(ElementNode, "br"))
+		root.content = append(root.content, row)
+	}
+	return root
+}
+
+func BenchmarkString(b *testing.B) {
+	tree := wideTree(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = tree.String()
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 2000; i++ {
+		sb.WriteString("(div.row :data-index \"row number\" (p \"some <quoted> text, with escapes\\n\") " +
+			"(a :href /some/longer/path/to/a/page.html symbol-content) ; a comment\n)\n")
+	}
+	in := sb.String()
+	b.SetBytes(int64(len(in)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(in); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package htl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+)
+
+// Forms expanded by ExpandIncludes.
+const (
+	// (include :src path) is replaced by the top-level nodes of the htl file
+	// at path.
+	includeTag = "include"
+
+	// onceAttr, as in (include :src style.htl :once once), drops an include
+	// of a file the document has included before.  Like a boolean attribute
+	// of html, it counts whatever its value.
+	onceAttr = "once"
+)
+
+// ExpandIncludes replaces, in the tree rooted at root, each include element
+// by the nodes of the htl file it names, themselves with their includes
+// expanded.  Files are read from baseDir, the way Inline reads them, and told
+// apart by their path under it, so that parts/a.htl and /parts/./a.htl are
+// the same file.  Going through the tree in document order, an include
+// marked :once of a file already included, marked or not, is dropped, as for
+// a stylesheet several components share.  The tree is rewritten in place, as
+// by Transform; the new root is returned.  It fails on the first include it
+// cannot expand, such as one of a missing file or of a file including itself.
+func ExpandIncludes(root *Node, baseDir string) (*Node, error) {
+	x := &includer{baseDir: baseDir, seen: map[string]bool{}}
+	return x.expand(root, nil)
+}
+
+// includer expands includes, remembering the files included so far.
+type includer struct {
+	baseDir string
+	seen    map[string]bool
+}
+
+// expand expands the includes of the tree rooted at root, part of the files
+// in stack, each including the next.
+func (x *includer) expand(root *Node, stack []string) (*Node, error) {
+	var err error
+	root = Transform(root, func(n *Node) *Node {
+		if n.kind != ElementNode || n.tag != includeTag || err != nil {
+			return n
+		}
+		var nodes []*Node
+		if nodes, err = x.include(n, stack); err != nil || len(nodes) == 0 {
+			return nil
+		}
+		return Fragment(nodes...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// include returns the expanded nodes of the file n includes, or none if it is
+// included once already.
+func (x *includer) include(n *Node, stack []string) ([]*Node, error) {
+	src := n.attr["src"]
+	if src == "" || n.rawAttr["src"] || len(n.content) > 0 {
+		return nil, fmt.Errorf("%s: want (%s :src path [:%s %s])", includeTag, includeTag, onceAttr, onceAttr)
+	}
+	u, err := url.Parse(src)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return nil, fmt.Errorf("%s %q: can only include a local file", includeTag, src)
+	}
+	p := path.Clean("/" + u.Path)
+	for _, s := range stack {
+		if s == p {
+			return nil, fmt.Errorf("%s %q: includes itself", includeTag, src)
+		}
+	}
+	if _, once := n.attr[onceAttr]; once && x.seen[p] {
+		return nil, nil
+	}
+	x.seen[p] = true
+
+	data, err := ioutil.ReadFile(filepath.Join(x.baseDir, filepath.FromSlash(p)))
+	if err != nil {
+		return nil, fmt.Errorf("%s %q: %v", includeTag, src, err)
+	}
+	tree, err := Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s %q: %v", includeTag, src, err)
+	}
+	if tree, err = x.expand(tree, append(stack, p)); err != nil || tree == nil {
+		return nil, err
+	}
+	return tree.content, nil
+}
@@ -0,0 +1,81 @@
+package htl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandIncludes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"style.htl":      "(style \"p { color: red }\")",
+		"parts/card.htl": "(include :src /style.htl :once once) (div.card card)",
+		"parts/nav.htl":  "(nav (a :href / Home))",
+		"empty.htl":      "; nothing\n",
+		"loop.htl":       "(p (include :src ./loop.htl))",
+		"bad.htl":        "(p",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		in   string
+		want string // "" for an error.
+	}{
+		{"(body (include :src parts/nav.htl) (main (include :src parts/card.htl) (include :src /parts/./card.htl)))",
+			"<body><nav><a href=\"/\">Home</a></nav><main>" +
+				"<style>p { color: red }</style><div class=\"card\">card</div>" +
+				"<div class=\"card\">card</div></main></body>"},
+		// Without :once, a file is included each time.
+		{"(head (include :src style.htl) (include :src style.htl))",
+			"<head><style>p { color: red }</style><style>p { color: red }</style></head>"},
+		// An include marked :once is dropped after any include of its file.
+		{"(head (include :src style.htl) (include :src parts/card.htl))",
+			"<head><style>p { color: red }</style><div class=\"card\">card</div></head>"},
+		{"(p (include :src empty.htl) x)", "<p>x</p>"},
+
+		{"(include :src loop.htl)", ""},
+		{"(include :src missing.htl)", ""},
+		{"(include :src bad.htl)", ""},
+		{"(include :src https://example.com/a.htl)", ""},
+		{"(include :href style.htl)", ""},
+		{"(include :src style.htl x)", ""},
+	}
+	for _, c := range cases {
+		root, err := Parse(c.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.in, err)
+		}
+		expanded, err := ExpandIncludes(root, dir)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("ExpandIncludes(%q) = %q, want an error", c.in, expanded)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ExpandIncludes(%q): %v", c.in, err)
+			continue
+		}
+		if got := expanded.String(); got != c.want {
+			t.Errorf("ExpandIncludes(%q):\n  got: %q\n want: %q", c.in, got, c.want)
+		}
+	}
+
+	root, err := Parse("(include :src loop.htl)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExpandIncludes(root, dir); err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Errorf("ExpandIncludes of a loop: %v, want it to include itself", err)
+	}
+}
@@ -0,0 +1,91 @@
+package htl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// inlineAttr marks a stylesheet link or an external script for Inline, as in
+// (link :rel stylesheet :href site.css :data-inline "").
+const inlineAttr = "data-inline"
+
+// Inline replaces, in the tree rooted at root, each element marked with
+// data-inline by the file it refers to: a stylesheet link by a style element
+// holding the stylesheet, and a script with a src by one holding the script.
+// The files are read from baseDir: a.css and /css/a.css alike are taken
+// relative to it, and no path leads out of it.  Elements not marked are left
+// alone.  The tree is rewritten in place, as by Transform;
+// the new root is returned.  It fails on the first element it cannot inline,
+// such as one referring to a missing file or to another host.
+func Inline(root *Node, baseDir string) (*Node, error) {
+	var err error
+	root = Transform(root, func(n *Node) *Node {
+		if _, marked := n.attr[inlineAttr]; !marked || n.kind != ElementNode || err != nil {
+			return n
+		}
+		inlined, inlineErr := inline(n, baseDir)
+		if inlineErr != nil {
+			err = inlineErr
+			return n
+		}
+		return inlined
+	})
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// inline returns the element replacing n, marked with data-inline.
+func inline(n *Node, baseDir string) (*Node, error) {
+	var ref, tag string
+	var keep []string // Attributes carried over.
+	switch {
+	case n.tag == "link" && hasToken(n.attr["rel"], "stylesheet"):
+		ref, tag, keep = n.attr["href"], "style", []string{"media", "nonce"}
+	case n.tag == "script" && n.attr["src"] != "":
+		ref, tag = n.attr["src"], "script"
+		for k := range n.attr {
+			if k != "src" && k != inlineAttr && k != "async" && k != "defer" {
+				keep = append(keep, k)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%s on %s: can only inline a stylesheet link or a script with a src", inlineAttr, n.tag)
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return nil, fmt.Errorf("%s %q: can only inline a local file", inlineAttr, ref)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(baseDir, filepath.FromSlash(path.Clean("/"+u.Path))))
+	if err != nil {
+		return nil, fmt.Errorf("%s %q: %v", inlineAttr, ref, err)
+	}
+	content := string(data)
+	if strings.Contains(strings.ToLower(content), "</"+tag) {
+		return nil, fmt.Errorf("%s %q: holds </%s, which would end the %s element early", inlineAttr, ref, tag, tag)
+	}
+	inlined := Element(tag, Raw(content))
+	for _, k := range keep {
+		if v, has := n.attr[k]; has {
+			inlined.attr[k] = v
+			inlined.setRawAttr(k, n.rawAttr[k])
+		}
+	}
+	return inlined, nil
+}
+
+// hasToken reports whether the space-separated list s, such as the value of
+// rel, holds token, regardless of case.
+func hasToken(s, token string) bool {
+	for _, t := range strings.Fields(s) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
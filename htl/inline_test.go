@@ -0,0 +1,65 @@
+package htl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInline(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.css":      "p { color: red }",
+		"js/b.js":    "var b = 1 < 2;",
+		"bad.css":    "p {} </STYLE><script>",
+		"sub/c.css":  "c {}",
+		"sub/ignore": "",
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		in, want, inErr string
+	}{
+		{"(head (link :rel stylesheet :href a.css :media print :data-inline \"\") (link :rel stylesheet :href a.css))",
+			"<head><style media=\"print\">p { color: red }</style><link href=\"a.css\" rel=\"stylesheet\"/></head>", ""},
+		{"(head (link :rel \"preload Stylesheet\" :href \"/sub/c.css?v=2\" :data-inline \"\"))",
+			"<head><style>c {}</style></head>", ""},
+		{"(body (script :src js/b.js :type module :defer \"\" :data-inline \"\"))",
+			"<body><script type=\"module\">var b = 1 < 2;</script></body>", ""},
+		{"(link :rel stylesheet :href missing.css :data-inline \"\")", "", "missing.css"},
+		{"(link :rel stylesheet :href https://example.com/a.css :data-inline \"\")", "", "local file"},
+		{"(link :rel stylesheet :href bad.css :data-inline \"\")", "", "</style"},
+		{"(link :rel icon :href a.css :data-inline \"\")", "", "stylesheet link"},
+		{"(img :src a.css :data-inline \"\")", "", "stylesheet link"},
+	}
+	for _, c := range cases {
+		tree, err := Parse(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err = Inline(tree, dir)
+		if c.inErr != "" {
+			if err == nil || !strings.Contains(err.Error(), c.inErr) {
+				t.Errorf("Inline(%q) error = %v, want one mentioning %q", c.in, err, c.inErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Inline(%q): %v", c.in, err)
+			continue
+		}
+		if got := tree.String(); got != c.want {
+			t.Errorf("Inline(%q):\n  got: %q\n want: %q", c.in, got, c.want)
+		}
+	}
+}
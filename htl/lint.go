@@ -0,0 +1,90 @@
+package htl
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+)
+
+// LintRules picks the checks Lint makes.
+type LintRules struct {
+	// DangerousURLs flags attributes holding a URL, like href and src, whose
+	// scheme runs script, like javascript:, or can carry any document, like
+	// data:.  Images may still have a data: src.
+	DangerousURLs bool
+
+	// MissingAlt flags img elements with no alt attribute.  An empty alt, for
+	// an image that is only decoration, is fine.
+	MissingAlt bool
+}
+
+// dangerousSchemes are the URL schemes DangerousURLs flags.
+var dangerousSchemes = map[string]bool{"javascript": true, "vbscript": true, "data": true}
+
+// Lint checks the tree rooted at root against rules and returns a warning for
+// each problem found, in document order.  Trees carry no positions, so the
+// diagnostics have none either; their messages name the element.
+func Lint(root *Node, rules LintRules) []Diagnostic {
+	var diags []Diagnostic
+	warn := func(format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{Severity: SeverityWarning, Msg: fmt.Sprintf(format, args...)})
+	}
+	root.Walk(func(n *Node) bool {
+		if n.kind != ElementNode {
+			return true
+		}
+		if rules.DangerousURLs {
+			for _, k := range sortedAttrKeys(n) {
+				if !urlAttrs[strings.ToLower(k)] {
+					continue
+				}
+				v := n.attr[k]
+				if n.rawAttr[k] {
+					v = html.UnescapeString(v)
+				}
+				scheme := urlScheme(v)
+				if dangerousSchemes[scheme] && !(scheme == "data" && n.tag == "img" && k == "src") {
+					warn("%s :%s: dangerous URL scheme %s:", n.tag, k, scheme)
+				}
+			}
+		}
+		if _, has := n.attr["alt"]; rules.MissingAlt && n.tag == "img" && !has {
+			if src, has := n.attr["src"]; has {
+				warn("img :src %s: missing :alt", src)
+			} else {
+				warn("img: missing :alt")
+			}
+		}
+		return true
+	})
+	return diags
+}
+
+// urlScheme returns the scheme of the URL v, lowercased, or "" if it has none,
+// reading it as browsers do: they ignore the whitespace and control
+// characters in it, so that "java\tscript:" runs script too.
+func urlScheme(v string) string {
+	var b strings.Builder
+	for _, r := range v {
+		if r > ' ' && r != 0x7f {
+			b.WriteRune(r)
+		}
+	}
+	v = b.String()
+	colon := strings.IndexByte(v, ':')
+	if colon <= 0 || strings.ContainsAny(v[:colon], "/?#") {
+		return ""
+	}
+	return strings.ToLower(v[:colon])
+}
+
+// sortedAttrKeys returns the attribute names of n, sorted.
+func sortedAttrKeys(n *Node) []string {
+	keys := make([]string, 0, len(n.attr))
+	for k := range n.attr {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,47 @@
+package htl
+
+import "testing"
+
+func TestLint(t *testing.T) {
+	all := LintRules{DangerousURLs: true, MissingAlt: true}
+	cases := []struct {
+		in    string
+		rules LintRules
+		want  []string
+	}{
+		{"(a :href \"javascript:alert(1)\" x)", all,
+			[]string{"warning: a :href: dangerous URL scheme javascript:"}},
+		{"(a :href (raw \" Java&#x09;Script:alert(1)\") x)", all,
+			[]string{"warning: a :href: dangerous URL scheme javascript:"}},
+		{"(p (img :src a.png) (img :src a.png :alt \"\") (img :src \"data:image/png;base64,AA==\" :alt x))", all,
+			[]string{"warning: img :src a.png: missing :alt"}},
+		{"(form :action \"vbscript:x\" (iframe :src \"data:text/html,<b>\"))", all,
+			[]string{"warning: form :action: dangerous URL scheme vbscript:",
+				"warning: iframe :src: dangerous URL scheme data:"}},
+		{"(p (a :href \"/javascript:x\" :title \"javascript:x\") (a :href \"https://a.test/?q=javascript:\"))", all, nil},
+		{"(a :href \"javascript:x\" (img :src a.png))", LintRules{}, nil},
+		{"(a :href \"javascript:x\" (img :src a.png))", LintRules{MissingAlt: true},
+			[]string{"warning: img :src a.png: missing :alt"}},
+	}
+	for _, c := range cases {
+		tree, err := Parse(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		diags := Lint(tree, c.rules)
+		got := []string{}
+		for _, d := range diags {
+			got = append(got, d.String())
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("Lint(%q) = %q, want %q", c.in, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("Lint(%q) = %q, want %q", c.in, got, c.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package htl
+
+import "fmt"
+
+// MergeStrategy says how Merge lays an overlay onto a base document.
+type MergeStrategy int
+
+const (
+	// MergeByID replaces each element of the base whose id is that of a
+	// top-level element of the overlay with the latter, attributes and all.
+	MergeByID MergeStrategy = iota
+
+	// MergeIntoSlots appends each top-level element of the overlay, as in
+	// (p :slot main ...), to the content of the element of the base marked
+	// with the same data-slot, as in (main :data-slot main), after what it
+	// holds already.  The slot attribute is dropped on the way.
+	MergeIntoSlots
+)
+
+// slotAttr names, on an overlay element, the slot MergeIntoSlots appends it
+// to, and dataSlotAttr marks that slot in the base.
+const (
+	slotAttr     = "slot"
+	dataSlotAttr = "data-slot"
+)
+
+// Merge returns a copy of the document base with the top-level nodes of
+// overlay, or overlay itself if it is not a document root, laid onto it by
+// strategy, as when filling a base layout with the content of a page.
+// Neither base nor overlay is modified.
+//
+// Merge fails rather than guess: on an overlay node with no id, or no slot,
+// to match, such as text; on one matching nothing in base; on two overlay
+// nodes with the same id; and on an id or slot that base has more than once.
+func Merge(base, overlay *Node, strategy MergeStrategy) (*Node, error) {
+	if base == nil {
+		return nil, fmt.Errorf("merge: no base document")
+	}
+	key := "id"
+	switch strategy {
+	case MergeByID:
+	case MergeIntoSlots:
+		key = slotAttr
+	default:
+		return nil, fmt.Errorf("merge: unknown strategy %d", strategy)
+	}
+	baseKey := key
+	if strategy == MergeIntoSlots {
+		baseKey = dataSlotAttr
+	}
+
+	nodes := []*Node{}
+	if overlay != nil {
+		nodes = []*Node{overlay}
+		if overlay.kind == ElementNode && overlay.tag == "" {
+			nodes = overlay.content
+		}
+	}
+	merged := map[string][]*Node{} // Overlay nodes, by the id or slot they go to.
+	for _, n := range nodes {
+		v, has := n.attr[key]
+		if n.kind != ElementNode || !has {
+			return nil, fmt.Errorf("merge: %s has no %s to match", describe(n), key)
+		}
+		if strategy == MergeByID && len(merged[v]) > 0 {
+			return nil, fmt.Errorf("merge: id %s is in the overlay twice", v)
+		}
+		merged[v] = append(merged[v], n)
+	}
+
+	root := copyTree(base)
+	targets := map[string]*Node{}
+	var err error
+	root.Walk(func(n *Node) bool {
+		v, has := n.attr[baseKey]
+		if n.kind != ElementNode || !has || err != nil {
+			return err == nil
+		}
+		if _, dup := targets[v]; dup {
+			err = fmt.Errorf("merge: %s %s is in the base twice", baseKey, v)
+		}
+		targets[v] = n
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for v := range merged {
+		if targets[v] == nil {
+			return nil, fmt.Errorf("merge: the base has no %s %s", baseKey, v)
+		}
+	}
+
+	if strategy == MergeIntoSlots {
+		for _, n := range nodes {
+			c := copyTree(n)
+			delete(c.attr, slotAttr)
+			c.setRawAttr(slotAttr, false)
+			target := targets[n.attr[slotAttr]]
+			target.content = append(target.content, c)
+		}
+		return root, nil
+	}
+	return Transform(root, func(n *Node) *Node {
+		if v, has := n.attr["id"]; has && targets[v] == n && len(merged[v]) > 0 {
+			return copyTree(merged[v][0])
+		}
+		return n
+	}), nil
+}
+
+// describe names n for an error message.
+func describe(n *Node) string {
+	if n.kind == ElementNode {
+		return "element " + n.tag
+	}
+	return fmt.Sprintf("text %q", n.tag)
+}
@@ -0,0 +1,81 @@
+package htl
+
+import "testing"
+
+func TestMerge(t *testing.T) {
+	layout := `(html
+  (head (title#title Site))
+  (body
+    (nav#nav (a :href / Home))
+    (main#main :data-slot main (p default))
+    (footer :data-slot footer)))`
+	cases := []struct {
+		overlay  string
+		strategy MergeStrategy
+		want     string // "" for an error.
+	}{
+		{`(title#title "My page") (main#main.wide (h1 Hello))`, MergeByID,
+			`<html><head><title id="title">My page</title></head><body>` +
+				`<nav id="nav"><a href="/">Home</a></nav>` +
+				`<main class="wide" id="main"><h1>Hello</h1></main>` +
+				`<footer data-slot="footer"></footer></body></html>`},
+		{`(h1 :slot main Hello) (p :slot footer "(c) me") (p :slot main more)`, MergeIntoSlots,
+			`<html><head><title id="title">Site</title></head><body>` +
+				`<nav id="nav"><a href="/">Home</a></nav>` +
+				`<main data-slot="main" id="main"><p>default</p><h1>Hello</h1><p>more</p></main>` +
+				`<footer data-slot="footer"><p>(c) me</p></footer></body></html>`},
+
+		{`(p#missing x)`, MergeByID, ""},
+		{`(p x)`, MergeByID, ""},
+		{`stray`, MergeByID, ""},
+		{`(p#nav a) (p#nav b)`, MergeByID, ""},
+		{`(p :slot aside x)`, MergeIntoSlots, ""},
+		{`(p#main x)`, MergeIntoSlots, ""},
+		{`(p#main x)`, MergeStrategy(7), ""},
+	}
+	for _, c := range cases {
+		base, err := Parse(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := base.String()
+		overlay, err := Parse(c.overlay)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.overlay, err)
+		}
+		merged, err := Merge(base, overlay, c.strategy)
+		if base.String() != before {
+			t.Errorf("Merge(%q) modified the base", c.overlay)
+		}
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("Merge(%q, %d) = %q, want an error", c.overlay, c.strategy, merged)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Merge(%q, %d): %v", c.overlay, c.strategy, err)
+			continue
+		}
+		if got := merged.String(); got != c.want {
+			t.Errorf("Merge(%q, %d):\n  got: %q\n want: %q", c.overlay, c.strategy, got, c.want)
+		}
+	}
+
+	base, err := Parse(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := Merge(base, nil, MergeByID)
+	if err != nil || !merged.Equal(base) || merged == base {
+		t.Errorf("Merge of no overlay = %v, %v; want a copy of the base", merged, err)
+	}
+
+	base, err = Parse("(div#a (p#a x))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Merge(base, Element("p").SetAttr("id", "a"), MergeByID); err == nil {
+		t.Errorf("Merge into a base with a duplicate id succeeded, want an error")
+	}
+}
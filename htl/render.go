@@ -0,0 +1,437 @@
+package htl
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// Data holds the values a tree is rendered against.  Values are substituted
+// for {{name}} placeholders in text and attribute values; a placeholder whose
+// name is missing is left as is, so it can still be filled in client-side.
+// Slices can be iterated with the for form, or a template with :each.
+//
+// Substituted values are escaped for where they land.  In attributes holding a
+// URL, like href and src, a value starting the attribute is taken as a URL:
+// characters not allowed in URLs are percent-encoded and a scheme other than
+// http, https or mailto is replaced by a harmless one.  A value further along
+// is path-escaped, so that "John Smith" in (a :href "/user/{{name}}") yields
+// /user/John%20Smith, unless it follows a ? or = in the attribute: then it is
+// query-escaped, so that "a b&c" in (a :href "/find?q={{q}}") yields
+// /find?q=a+b%26c.  Elsewhere values are html-escaped when the tree is written
+// out, like the text around them.  Values of type Safe are html, substituted
+// as is: the text node or attribute value they land in becomes raw, with the
+// rest of it escaped.  Raw nodes and raw attribute values are copied without
+// substitution.
+type Data map[string]interface{}
+
+// Safe is a value that Render substitutes without escaping, such as a full
+// URL or a snippet of html known to be safe.
+type Safe string
+
+// urlAttrs are the attributes whose value is a URL.
+var urlAttrs = map[string]bool{
+	"action": true, "cite": true, "formaction": true, "href": true,
+	"poster": true, "src": true,
+}
+
+// safeSchemes are the URL schemes a substituted value may start a URL with.
+var safeSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// unsafeURL replaces a URL whose scheme is not one of safeSchemes.
+const unsafeURL = "about:invalid#unsafe"
+
+// escapeURL escapes a substituted value in an attribute holding a URL, after
+// the text before it in the attribute.
+func escapeURL(v, before string) string {
+	if strings.ContainsAny(before, "?=") {
+		return url.QueryEscape(v)
+	}
+	if before != "" {
+		return url.PathEscape(v)
+	}
+	if u, err := url.Parse(v); err != nil || (u.Scheme != "" && !safeSchemes[strings.ToLower(u.Scheme)]) {
+		return unsafeURL
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\"'<>\\^`{|}", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Special forms expanded by Render.  Each one is written like an element,
+// with the tag naming the form.
+const (
+	// (if key then [else]) keeps then when data[key] is true, else otherwise.
+	// A missing key counts as false.
+	ifTag = "if"
+
+	// (for name key body...) repeats body once per item of the slice
+	// data[key], with data[name] bound to the item.  A missing key or an empty
+	// slice emits nothing.
+	forTag = "for"
+
+	// (spread key [prefix]), as a child of an element, adds the entries of
+	// the map data[key] to the attributes of the element, with their names
+	// prefixed by prefix, as in (div (spread config data-)).  Attributes
+	// written on the element, or spread by an earlier spread, win.  A true
+	// value sets a boolean attribute, and a false or nil one leaves it out.
+	// A missing key adds nothing.
+	spreadTag = "spread"
+
+	// (template :each key [:as name] body...) yields a template element
+	// holding body, followed by body repeated once per item of the slice
+	// data[key], with data[name] bound to the item, as for does.  name is
+	// "item" unless given.  The template holds body rendered with name unbound,
+	// so its {{name}} placeholders are left for a client script to fill in
+	// when it adds items; its other attributes, such as an id to find it by,
+	// are kept.  A missing key yields the template alone.  A template without
+	// :each is an ordinary element.
+	templateTag = "template"
+)
+
+// Attributes of the template form, and the name of the item by default.
+const (
+	eachAttr        = "each"
+	asAttr          = "as"
+	defaultItemName = "item"
+)
+
+// Render returns a copy of root with the special forms expanded against data.
+// root itself is left untouched, so a parsed tree can be rendered repeatedly.
+func Render(root *Node, data Data) (*Node, error) {
+	if root == nil {
+		return nil, nil
+	}
+	nodes, err := render(root, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return Element("", nodes...), nil // a special form at the root.
+}
+
+// render expands t into zero or more nodes.
+func render(t *Node, data Data) ([]*Node, error) {
+	switch t.kind {
+	case TextNode:
+		text, raw := substitute(t.tag, data, nil)
+		if raw {
+			return []*Node{NewNode(RawNode, text)}, nil
+		}
+		return []*Node{NewNode(TextNode, text)}, nil
+	case RawNode:
+		return []*Node{NewNode(RawNode, t.tag)}, nil
+	}
+	switch t.tag {
+	case ifTag:
+		return renderIf(t, data)
+	case forTag:
+		return renderFor(t, data)
+	case spreadTag:
+		return nil, fmt.Errorf("%s: may only be a child of an element", spreadTag)
+	}
+	if _, has := t.attr[eachAttr]; has && t.tag == templateTag {
+		return renderTemplate(t, data)
+	}
+
+	n := NewNode(t.kind, t.tag)
+	for k, v := range t.attr {
+		if t.rawAttr[k] {
+			n.SetRawAttr(k, v)
+			continue
+		}
+		var escape func(string, string) string
+		if urlAttrs[strings.ToLower(k)] {
+			escape = escapeURL
+		}
+		var raw bool
+		n.attr[k], raw = substitute(v, data, escape)
+		n.setRawAttr(k, raw)
+	}
+	for _, c := range t.content {
+		if c.kind == ElementNode && c.tag == spreadTag {
+			if err := renderSpread(c, n, data); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		nodes, err := render(c, data)
+		if err != nil {
+			return nil, err
+		}
+		n.content = append(n.content, nodes...)
+	}
+	return []*Node{n}, nil
+}
+
+// renderSpread adds the attributes spread by t to n, keeping those n already
+// has.
+func renderSpread(t, n *Node, data Data) error {
+	if n.tag == "" {
+		return fmt.Errorf("%s: may only be a child of an element", spreadTag)
+	}
+	if len(t.content) < 1 || len(t.content) > 2 || t.content[0].kind != TextNode ||
+		len(t.content) == 2 && t.content[1].kind != TextNode {
+		return fmt.Errorf("%s: want (%s key [prefix])", spreadTag, spreadTag)
+	}
+	key, prefix := t.content[0].tag, ""
+	if len(t.content) == 2 {
+		prefix = t.content[1].tag
+	}
+	v, has := data[key]
+	if !has {
+		return nil
+	}
+	attrs := reflect.ValueOf(v)
+	if attrs.Kind() != reflect.Map || attrs.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("%s: %q is a %T, not a map with string keys", spreadTag, key, v)
+	}
+	for _, k := range attrs.MapKeys() {
+		name := prefix + k.String()
+		if name == "" || strings.ContainsAny(name, " \t\n\f\r\"'<>/=&") {
+			return fmt.Errorf("%s: %q is not a valid attribute name", spreadTag, name)
+		}
+		if _, has := n.attr[name]; has {
+			continue
+		}
+		value := attrs.MapIndex(k).Interface()
+		switch value := value.(type) {
+		case nil:
+		case bool:
+			if value {
+				n.attr[name] = name
+			}
+		case Safe:
+			n.SetRawAttr(name, string(value))
+		default:
+			v := fmt.Sprint(value)
+			if urlAttrs[strings.ToLower(name)] {
+				v = escapeURL(v, "")
+			}
+			n.attr[name] = v
+		}
+	}
+	return nil
+}
+
+func renderIf(t *Node, data Data) ([]*Node, error) {
+	if len(t.content) < 2 || len(t.content) > 3 || t.content[0].kind != TextNode {
+		return nil, fmt.Errorf("%s: want (%s key then [else])", ifTag, ifTag)
+	}
+	key := t.content[0].tag
+	cond := false
+	if v, has := data[key]; has {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s: %q is a %T, not a bool", ifTag, key, v)
+		}
+		cond = b
+	}
+	switch {
+	case cond:
+		return render(t.content[1], data)
+	case len(t.content) == 3:
+		return render(t.content[2], data)
+	default:
+		return nil, nil
+	}
+}
+
+func renderFor(t *Node, data Data) ([]*Node, error) {
+	if len(t.content) < 2 || t.content[0].kind != TextNode || t.content[1].kind != TextNode {
+		return nil, fmt.Errorf("%s: want (%s name key body...)", forTag, forTag)
+	}
+	name, key := t.content[0].tag, t.content[1].tag
+	v, has := data[key]
+	if !has {
+		return nil, nil
+	}
+	items := reflect.ValueOf(v)
+	if items.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("%s: %q is a %T, not a slice", forTag, key, v)
+	}
+	return renderEach(t.content[2:], name, items, data)
+}
+
+func renderTemplate(t *Node, data Data) ([]*Node, error) {
+	key, name := t.attr[eachAttr], defaultItemName
+	if as, has := t.attr[asAttr]; has {
+		name = as
+	}
+	if key == "" || name == "" || t.rawAttr[eachAttr] || t.rawAttr[asAttr] {
+		return nil, fmt.Errorf("%s: want (%s :%s key [:%s name] body...)", templateTag, templateTag, eachAttr, asAttr)
+	}
+
+	tmpl := NewNode(ElementNode, templateTag)
+	for k, v := range t.attr {
+		if k != eachAttr && k != asAttr {
+			tmpl.attr[k] = v
+			tmpl.setRawAttr(k, t.rawAttr[k])
+		}
+	}
+	tmpl.content = t.content
+	unbound := Data{}
+	for k, v := range data {
+		if k != name {
+			unbound[k] = v
+		}
+	}
+	nodes, err := render(tmpl, unbound)
+	if err != nil {
+		return nil, err
+	}
+
+	v, has := data[key]
+	if !has {
+		return nodes, nil
+	}
+	items := reflect.ValueOf(v)
+	if items.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("%s: %q is a %T, not a slice", templateTag, key, v)
+	}
+	instances, err := renderEach(t.content, name, items, data)
+	if err != nil {
+		return nil, err
+	}
+	return append(nodes, instances...), nil
+}
+
+// renderEach renders body once per item of items, with data[name] bound to
+// the item.
+func renderEach(body []*Node, name string, items reflect.Value, data Data) ([]*Node, error) {
+	nodes := []*Node{}
+	scope := Data{}
+	for k, v := range data {
+		scope[k] = v
+	}
+	for i := 0; i < items.Len(); i++ {
+		scope[name] = items.Index(i).Interface()
+		for _, c := range body {
+			rendered, err := render(c, scope)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, rendered...)
+		}
+	}
+	return nodes, nil
+}
+
+// substitute replaces the {{name}} placeholders in s with the values found in
+// data, escaped with escape, if not nil, unless they are Safe.  escape is given
+// the text of the result before the value.  If a value is Safe, the result is html, with
+// the rest of it html-escaped, and raw is true.
+func substitute(s string, data Data, escape func(v, before string) string) (result string, raw bool) {
+	if !strings.Contains(s, "{{") {
+		return s, false
+	}
+	// b gets the text, and h the same as html, in case a value is Safe.
+	var b, h strings.Builder
+	for {
+		start := strings.Index(s, "{{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(s[start:], "}}")
+		if end < 0 {
+			break
+		}
+		end += start + len("}}")
+		b.WriteString(s[:start])
+		h.WriteString(htmlEscape(s[:start]))
+		if v, has := data[strings.TrimSpace(s[start+2:end-2])]; !has {
+			b.WriteString(s[start:end])
+			h.WriteString(htmlEscape(s[start:end]))
+		} else if safe, ok := v.(Safe); ok {
+			h.WriteString(string(safe))
+			raw = true
+		} else {
+			v := fmt.Sprint(v)
+			if escape != nil {
+				v = escape(v, b.String())
+			}
+			b.WriteString(v)
+			h.WriteString(htmlEscape(v))
+		}
+		s = s[end:]
+	}
+	if raw {
+		h.WriteString(htmlEscape(s))
+		return h.String(), true
+	}
+	b.WriteString(s)
+	return b.String(), false
+}
+
+// envPrefix starts the name of a placeholder that ExpandEnv fills in, as in
+// {{env:GIT_SHA}}.
+const envPrefix = "env:"
+
+// ExpandEnv replaces, in place, the {{env:NAME}} placeholders in the text and
+// attribute values of the tree rooted at root with lookup(NAME), such as
+// os.LookupEnv, as a build step would.  Values are html-escaped when the tree
+// is written out, like the text around them, except in raw nodes and raw
+// attribute values.  Other placeholders, like {{name}}, are
+// left for Render or the client.  It fails on the first NAME lookup does not
+// find, leaving the tree partly expanded.
+func ExpandEnv(root *Node, lookup func(name string) (string, bool)) error {
+	var err error
+	root.Walk(func(n *Node) bool {
+		if err != nil {
+			return false
+		}
+		switch n.kind {
+		case TextNode, RawNode:
+			n.tag, err = expandEnv(n.tag, lookup)
+		case ElementNode:
+			for k, v := range n.attr {
+				if n.attr[k], err = expandEnv(v, lookup); err != nil {
+					break
+				}
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+// expandEnv replaces the {{env:NAME}} placeholders in s with lookup(NAME).
+func expandEnv(s string, lookup func(string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "{{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(s[start:], "}}")
+		if end < 0 {
+			break
+		}
+		end += start + len("}}")
+		b.WriteString(s[:start])
+		name := strings.TrimSpace(s[start+2 : end-2])
+		if !strings.HasPrefix(name, envPrefix) {
+			b.WriteString(s[start:end])
+		} else if v, has := lookup(strings.TrimPrefix(name, envPrefix)); !has {
+			return "", fmt.Errorf("%s is not set", name)
+		} else {
+			b.WriteString(v)
+		}
+		s = s[end:]
+	}
+	b.WriteString(s)
+	return b.String(), nil
+}
@@ -0,0 +1,340 @@
+package htl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderIf(t *testing.T) {
+	in := "(p (if loggedIn (a :href /logout \"Logout\") (a :href /login \"Login\")))"
+	cases := []struct {
+		data Data
+		want string
+	}{
+		{Data{"loggedIn": true},
+			"<p><a href=\"/logout\">Logout</a></p>"},
+		{Data{"loggedIn": false},
+			"<p><a href=\"/login\">Login</a></p>"},
+		{Data{},
+			"<p><a href=\"/login\">Login</a></p>"},
+	}
+	tree, err := Parse(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		rendered, err := Render(tree, c.data)
+		if err != nil {
+			t.Errorf("Render(%v): %v", c.data, err)
+			continue
+		}
+		if got := rendered.String(); got != c.want {
+			t.Errorf("Render(%v):\n  got: %q\n want: %q", c.data, got, c.want)
+		}
+	}
+	if got, want := tree.String(), "<p><if>loggedIn<a href=\"/logout\">Logout</a><a href=\"/login\">Login</a></if></p>"; got != want {
+		t.Errorf("Render modified its input: %q", got)
+	}
+}
+
+func TestRenderIfErrors(t *testing.T) {
+	cases := []struct {
+		in   string
+		data Data
+	}{
+		{"(if)", Data{}},
+		{"(if x (a) (b) (c))", Data{}},
+		{"(if x (a))", Data{"x": "yes"}},
+	}
+	for _, c := range cases {
+		tree, err := Parse(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Render(tree, c.data); err == nil {
+			t.Errorf("Render(%q, %v) succeeded, want an error", c.in, c.data)
+		}
+	}
+}
+
+func TestRenderFor(t *testing.T) {
+	in := "(ul :class {{kind}} (for item items (li :title {{item}} {{item}})))"
+	cases := []struct {
+		data Data
+		want string
+	}{
+		{Data{"kind": "fruit", "items": []string{"apple", "b<c", "cherry"}},
+			"<ul class=\"fruit\">" +
+				"<li title=\"apple\">apple</li>" +
+				"<li title=\"b&lt;c\">b&lt;c</li>" +
+				"<li title=\"cherry\">cherry</li></ul>"},
+		{Data{"kind": "fruit", "items": []interface{}{}},
+			"<ul class=\"fruit\"></ul>"},
+		{Data{},
+			"<ul class=\"{{kind}}\"></ul>"},
+	}
+	tree, err := Parse(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		rendered, err := Render(tree, c.data)
+		if err != nil {
+			t.Errorf("Render(%v): %v", c.data, err)
+			continue
+		}
+		if got := rendered.String(); got != c.want {
+			t.Errorf("Render(%v):\n  got: %q\n want: %q", c.data, got, c.want)
+		}
+	}
+	if _, err := Render(tree, Data{"items": 3}); err == nil {
+		t.Errorf("Render over a non-slice succeeded, want an error")
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	in := "(ul (template#row :each rows :as row (li :data-id {{row}} {{row}} (if admin (b \"!\")))))"
+	cases := []struct {
+		data Data
+		want string
+	}{
+		{Data{"rows": []string{"a", "b<c"}, "admin": true},
+			"<ul><template id=\"row\"><li data-id=\"{{row}}\">{{row}}<b>!</b></li></template>" +
+				"<li data-id=\"a\">a<b>!</b></li>" +
+				"<li data-id=\"b&lt;c\">b&lt;c<b>!</b></li></ul>"},
+		{Data{"rows": []string{}, "row": "outer"},
+			"<ul><template id=\"row\"><li data-id=\"{{row}}\">{{row}}</li></template></ul>"},
+		{Data{},
+			"<ul><template id=\"row\"><li data-id=\"{{row}}\">{{row}}</li></template></ul>"},
+	}
+	tree, err := Parse(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		rendered, err := Render(tree, c.data)
+		if err != nil {
+			t.Errorf("Render(%v): %v", c.data, err)
+			continue
+		}
+		if got := rendered.String(); got != c.want {
+			t.Errorf("Render(%v):\n  got: %q\n want: %q", c.data, got, c.want)
+		}
+	}
+
+	parse := func(in string) *Node {
+		n, err := Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	rendered, err := Render(parse("(template :each items {{item}})"), Data{"items": []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rendered.String(), "<template>{{item}}</template>12"; got != want {
+		t.Errorf("Render with the default item name = %q, want %q", got, want)
+	}
+	rendered, err = Render(parse("(template :id x (p))"), Data{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rendered.String(), "<template id=\"x\"><p></p></template>"; got != want {
+		t.Errorf("Render of a template without :each = %q, want %q", got, want)
+	}
+	for _, in := range []string{"(template :each \"\" (p))", "(template :each rows :as \"\" (p))"} {
+		if _, err := Render(parse(in), Data{}); err == nil {
+			t.Errorf("Render(%q) succeeded, want an error", in)
+		}
+	}
+	if _, err := Render(tree, Data{"rows": "a"}); err == nil {
+		t.Errorf("Render over a non-slice succeeded, want an error")
+	}
+}
+
+func TestRenderOmitEmptyAttrs(t *testing.T) {
+	tree, err := Parse("(a :href {{url}} :title \"\" :class x \"go\")")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		data Data
+		opts Options
+		want string
+	}{
+		{Data{"url": "/home"}, Options{},
+			"<a class=\"x\" href=\"/home\" title=\"\">go</a>"},
+		{Data{"url": ""}, Options{},
+			"<a class=\"x\" href=\"\" title=\"\">go</a>"},
+		{Data{"url": "/home"}, Options{OmitEmptyAttrs: true},
+			"<a class=\"x\" href=\"/home\">go</a>"},
+		{Data{"url": ""}, Options{OmitEmptyAttrs: true},
+			"<a class=\"x\">go</a>"},
+	}
+	for _, c := range cases {
+		rendered, err := Render(tree, c.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rendered.Format(c.opts); got != c.want {
+			t.Errorf("Render(%v).Format(%+v):\n  got: %q\n want: %q", c.data, c.opts, got, c.want)
+		}
+	}
+}
+
+func TestRenderEscaping(t *testing.T) {
+	in := "(p (a :href \"/find?q={{q}}\" :title {{q}} \"{{q}}\") (img :src {{url}}) \"{{html}}\"" +
+		" (a :href \"/user/{{name}}/posts?tag={{name}}#{{name}}\"))"
+	tree, err := Parse(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := Data{
+		"q":    "a b&c",
+		"name": "John Smith/+?",
+		"url":  Safe("https://example.com/a.png"),
+		"html": Safe("<b>hi</b>"),
+	}
+	rendered, err := Render(tree, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<p><a href=\"/find?q=a+b%26c\" title=\"a b&amp;c\">a b&amp;c</a>" +
+		"<img src=\"https://example.com/a.png\"/><b>hi</b>" +
+		"<a href=\"/user/John%20Smith%2F+%3F/posts?tag=John+Smith%2F%2B%3F#John+Smith%2F%2B%3F\"></a></p>"
+	if got := rendered.String(); got != want {
+		t.Errorf("Render:\n  got: %q\n want: %q", got, want)
+	}
+
+	// A Safe value makes the text it lands in raw, with the rest escaped.
+	mixed, err := Parse("(p \"<{{html}}> & {{q}}\" :title \"{{html}}&\")")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err = Render(mixed, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "<p title=\"<b>hi</b>&amp;\">&lt;<b>hi</b>&gt; &amp; a b&amp;c</p>"
+	if got := rendered.String(); got != want {
+		t.Errorf("Render with Safe in text:\n  got: %q\n want: %q", got, want)
+	}
+	if got := rendered.content[0].content[0].Kind(); got != RawNode {
+		t.Errorf("Render with Safe in text: kind %v, want RawNode", got)
+	}
+
+	rendered, err = Render(tree, Data{"q": "x", "url": "javascript:alert(1)", "html": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rendered.String(); strings.Contains(got, "javascript:") || !strings.Contains(got, unsafeURL) {
+		t.Errorf("Render let a value set the scheme of a URL: %q", got)
+	}
+}
+
+func TestRenderRawAttr(t *testing.T) {
+	tree, err := Parse("(div :data-x (raw \"{{a}} <b>\") :title {{a}})")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := Render(tree, Data{"a": "<i>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<div data-x=\"{{a}} <b>\" title=\"&lt;i&gt;\"></div>"
+	if got := rendered.String(); got != want {
+		t.Errorf("Render:\n  got: %q\n want: %q", got, want)
+	}
+	if got, want := rendered.Format(Options{Quote: '\''}), "<div data-x='{{a}} <b>' title='&lt;i&gt;'></div>"; got != want {
+		t.Errorf("Format with single quotes:\n  got: %q\n want: %q", got, want)
+	}
+}
+
+func TestRenderSpread(t *testing.T) {
+	cases := []struct {
+		in   string
+		data Data
+		want string // "" for an error.
+	}{
+		{"(div (spread config data-) \"x\")",
+			Data{"config": map[string]int{"foo": 1, "bar": 2}},
+			"<div data-bar=\"2\" data-foo=\"1\">x</div>"},
+		{"(input :type text :value keep (spread attrs))",
+			Data{"attrs": Data{"value": "lost", "name": "q", "required": true, "disabled": false, "title": nil}},
+			"<input type=\"text\" name=\"q\" required=\"required\" value=\"keep\"></input>"},
+		{"(a (spread attrs) (spread more))",
+			Data{"attrs": map[string]string{"href": "javascript:x", "title": "<b>"},
+				"more": map[string]interface{}{"title": "later", "rel": Safe("a&b")}},
+			"<a href=\"about:invalid#unsafe\" rel=\"a&b\" title=\"&lt;b&gt;\"></a>"},
+		{"(div (spread missing))", Data{},
+			"<div></div>"},
+		{"(div (spread attrs))", Data{"attrs": []string{"a"}}, ""},
+		{"(div (spread attrs))", Data{"attrs": map[string]string{"a onclick": "x"}}, ""},
+		{"(div (spread attrs))", Data{"attrs": map[string]string{"x\"": "x"}}, ""},
+		{"(div (spread))", Data{}, ""},
+		{"(spread attrs)", Data{"attrs": map[string]string{}}, ""},
+		{"(div (if on (spread attrs)))", Data{"on": true, "attrs": map[string]string{}}, ""},
+	}
+	for _, c := range cases {
+		tree, err := Parse(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rendered, err := Render(tree, c.data)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("Render(%q, %v) = %q, want an error", c.in, c.data, rendered)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Render(%q, %v): %v", c.in, c.data, err)
+			continue
+		}
+		if got := rendered.String(); got != c.want {
+			t.Errorf("Render(%q, %v):\n  got: %q\n want: %q", c.in, c.data, got, c.want)
+		}
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"SHA": "abc123", "API": "https://api.example.com/v1?a=1&b=2", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, has := env[name]
+		return v, has
+	}
+	cases := []struct{ in, want string }{
+		{"(p \"build {{env:SHA}}\")", "<p>build abc123</p>"},
+		{"(p \"{{ env:SHA }}{{env:SHA}}\")", "<p>abc123abc123</p>"},
+		{"(a :href \"{{env:API}}\" (b \"{{user}}\"))",
+			"<a href=\"https://api.example.com/v1?a=1&amp;b=2\"><b>{{user}}</b></a>"},
+		{"(script (raw \"var api = '{{env:API}}';\"))", "<script>var api = 'https://api.example.com/v1?a=1&b=2';</script>"},
+		{"(div :data-api (raw \"{{env:API}}\") \"[{{env:EMPTY}}]\")",
+			"<div data-api=\"https://api.example.com/v1?a=1&b=2\">[]</div>"},
+		{"(p \"{{env:SHA\" \"}}\")", "<p>{{env:SHA}}</p>"},
+	}
+	for _, c := range cases {
+		tree, err := Parse(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ExpandEnv(tree, lookup); err != nil {
+			t.Errorf("ExpandEnv(%q): %v", c.in, err)
+			continue
+		}
+		if got := tree.String(); got != c.want {
+			t.Errorf("ExpandEnv(%q):\n  got: %q\n want: %q", c.in, got, c.want)
+		}
+	}
+
+	for _, in := range []string{"(p \"{{env:MISSING}}\")", "(p :title \"{{env:MISSING}}\")"} {
+		tree, err := Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ExpandEnv(tree, lookup); err == nil || !strings.Contains(err.Error(), "env:MISSING") {
+			t.Errorf("ExpandEnv(%q) = %v, want an error naming env:MISSING", in, err)
+		}
+	}
+}
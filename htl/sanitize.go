@@ -0,0 +1,117 @@
+package htl
+
+import (
+	"html"
+	"strings"
+)
+
+// SanitizePolicy says what Sanitize keeps of a tree.  Everything it does not
+// allow is removed.
+type SanitizePolicy struct {
+	// Tags are the elements kept.  Any other element is replaced by its
+	// content, unless it is in Drop.
+	Tags map[string]bool
+
+	// Drop are the elements removed along with their content, like script,
+	// whose content is not meant to be read as text.
+	Drop map[string]bool
+
+	// Attrs lists, by tag, the attributes kept on the elements; those listed
+	// under "*" are kept on any element.  URL attributes, like href and src,
+	// are removed anyway when their scheme runs script, as Lint's
+	// DangerousURLs finds.
+	Attrs map[string][]string
+
+	// AllowRaw keeps raw nodes and raw attribute values.  Otherwise they are
+	// removed, since they are written out unescaped.
+	AllowRaw bool
+}
+
+// DefaultSanitizePolicy keeps the elements and attributes of formatted text,
+// links, images, lists and tables, as user comments might use.  It drops
+// scripts, styles, frames and event handlers, and replaces other elements,
+// like forms, by their content.
+var DefaultSanitizePolicy = SanitizePolicy{
+	Tags: map[string]bool{
+		"a": true, "abbr": true, "b": true, "blockquote": true, "br": true,
+		"caption": true, "cite": true, "code": true, "dd": true, "del": true,
+		"div": true, "dl": true, "dt": true, "em": true, "figcaption": true,
+		"figure": true, "h1": true, "h2": true, "h3": true, "h4": true,
+		"h5": true, "h6": true, "hr": true, "i": true, "img": true,
+		"ins": true, "kbd": true, "li": true, "ol": true, "p": true,
+		"pre": true, "q": true, "s": true, "small": true, "span": true,
+		"strong": true, "sub": true, "sup": true, "table": true,
+		"tbody": true, "td": true, "tfoot": true, "th": true, "thead": true,
+		"tr": true, "u": true, "ul": true,
+	},
+	Drop: map[string]bool{
+		"embed": true, "frame": true, "frameset": true, "iframe": true,
+		"noscript": true, "object": true, "script": true, "style": true,
+		"template": true, "textarea": true, "title": true, "select": true,
+	},
+	Attrs: map[string][]string{
+		"*":          {"class", "dir", "lang", "title"},
+		"a":          {"href", "rel"},
+		"blockquote": {"cite"},
+		"img":        {"alt", "height", "src", "width"},
+		"ol":         {"start"},
+		"q":          {"cite"},
+		"td":         {"colspan", "rowspan"},
+		"th":         {"colspan", "rowspan"},
+	},
+}
+
+// Sanitize strips the tree rooted at root of what policy does not allow, as
+// untrusted input needs before it is served.  The tree is rewritten in place,
+// as by Transform; the new root is returned.
+func Sanitize(root *Node, policy SanitizePolicy) *Node {
+	return Transform(root, func(n *Node) *Node {
+		switch n.kind {
+		case TextNode:
+			return n
+		case RawNode:
+			if policy.AllowRaw {
+				return n
+			}
+			return nil
+		}
+		if n.tag == "" || n.tag == fragmentTag {
+			return n
+		}
+		tag := strings.ToLower(n.tag)
+		switch {
+		case n.tag == rawTag && !policy.AllowRaw, policy.Drop[tag]:
+			return nil
+		case !policy.Tags[tag] && n.tag != rawTag:
+			return Fragment(n.content...)
+		}
+		for k, v := range n.attr {
+			if !policy.allowsAttr(tag, k) || n.rawAttr[k] && !policy.AllowRaw {
+				delete(n.attr, k)
+				delete(n.rawAttr, k)
+				continue
+			}
+			if n.rawAttr[k] {
+				v = html.UnescapeString(v)
+			}
+			if urlAttrs[strings.ToLower(k)] && dangerousSchemes[urlScheme(v)] {
+				delete(n.attr, k)
+				delete(n.rawAttr, k)
+			}
+		}
+		return n
+	})
+}
+
+// allowsAttr reports whether the policy keeps attribute key on element tag.
+func (policy *SanitizePolicy) allowsAttr(tag, key string) bool {
+	key = strings.ToLower(key)
+	for _, list := range [][]string{policy.Attrs["*"], policy.Attrs[tag]} {
+		for _, k := range list {
+			if k == key {
+				return true
+			}
+		}
+	}
+	return false
+}
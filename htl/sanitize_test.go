@@ -0,0 +1,36 @@
+package htl
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"(div (p :onclick \"steal()\" :class note hi) (script \"steal()\"))",
+			"<div><p class=\"note\">hi</p></div>"},
+		{"(p (a :href \"javascript:steal()\" :target _blank x) (a :href \"https://a.test/\" :rel nofollow y))",
+			"<p><a>x</a><a href=\"https://a.test/\" rel=\"nofollow\">y</a></p>"},
+		{"(p (blink (b bold) text) (style \"p {}\") (iframe :src \"https://a.test/\"))",
+			"<p><b>bold</b>text</p>"},
+		{"(p (raw \"<script>steal()</script>\") :data-x (raw \"y\") (img :src a.png :alt a :style \"x\"))",
+			"<p><img alt=\"a\" src=\"a.png\"/></p>"},
+		{"(<> (form (input :name q)) (P :ID x :Title t ok))",
+			"<P Title=\"t\">ok</P>"},
+	}
+	for _, c := range cases {
+		tree, err := Parse(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Sanitize(tree, DefaultSanitizePolicy).String(); got != c.want {
+			t.Errorf("Sanitize(%q):\n  got: %q\n want: %q", c.in, got, c.want)
+		}
+	}
+
+	tree, err := Parse("(p (raw \"<i>x</i>\") (script y))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := SanitizePolicy{Tags: map[string]bool{"p": true}, AllowRaw: true}
+	if got, want := Sanitize(tree, policy).String(), "<p><i>x</i>y</p>"; got != want {
+		t.Errorf("Sanitize with AllowRaw:\n  got: %q\n want: %q", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+package htl
+
+import "strings"
+
+// hoistedTags are the elements Scaffold moves into the head when asked to.  A
+// script is moved only if it has a src, since an inline script may expect the
+// content before it.
+var hoistedTags = map[string]bool{"link": true, "meta": true, "title": true}
+
+// Scaffold wraps a document written without an html element, as a bare
+// fragment of body content, in the standard skeleton (html (head) (body)).
+// The top-level nodes of root, as returned by Parse, or root itself if it is
+// not a document root, go in the body, in order.  A top-level head or body
+// element gives its attributes and children to that of the skeleton.  With
+// hoist, top-level title, meta and link elements, and scripts with a src, go
+// in the head instead.  A prologue captured by Parse stays before the html
+// element.
+//
+// A document with a top-level html element is returned as is.  Otherwise the
+// result is a new root, which takes the nodes of root, so root should no
+// longer be used.
+func Scaffold(root *Node, hoist bool) *Node {
+	if root == nil {
+		return nil
+	}
+	nodes := []*Node{root}
+	if root.kind == ElementNode && root.tag == "" {
+		nodes = root.content
+	}
+	for _, n := range nodes {
+		if n.kind == ElementNode && strings.EqualFold(n.tag, "html") {
+			return root
+		}
+	}
+
+	doc, head, body := Element(""), Element("head"), Element("body")
+	for i, n := range nodes {
+		tag := strings.ToLower(n.tag)
+		switch {
+		case n.kind == RawNode && i == len(doc.content):
+			doc.content = append(doc.content, n) // a captured prologue.
+		case n.kind != ElementNode:
+			body.content = append(body.content, n)
+		case tag == "head":
+			mergeInto(head, n)
+		case tag == "body":
+			mergeInto(body, n)
+		case hoist && isHoisted(n, tag):
+			head.content = append(head.content, n)
+		default:
+			body.content = append(body.content, n)
+		}
+	}
+	doc.content = append(doc.content, Element("html", head, body))
+	return doc
+}
+
+// isHoisted reports whether Scaffold moves element n, whose lowercased tag is
+// tag, into the head.
+func isHoisted(n *Node, tag string) bool {
+	if tag == "script" {
+		_, has := n.attr["src"]
+		return has
+	}
+	return hoistedTags[tag]
+}
+
+// mergeInto gives the attributes and children of n to dst.
+func mergeInto(dst, n *Node) {
+	for k, v := range n.attr {
+		dst.attr[k] = v
+		dst.setRawAttr(k, n.rawAttr[k])
+	}
+	dst.content = append(dst.content, n.content...)
+}
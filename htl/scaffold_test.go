@@ -0,0 +1,48 @@
+package htl
+
+import "testing"
+
+func TestScaffold(t *testing.T) {
+	cases := []struct {
+		in    string
+		hoist bool
+		want  string
+	}{
+		{"(h1 Hi) (p text)", false,
+			"<html><head></head><body><h1>Hi</h1><p>text</p></body></html>"},
+		{"(p just-one)", true,
+			"<html><head></head><body><p>just-one</p></body></html>"},
+		{"(title T) (h1 Hi) (meta :charset utf-8) (script :src a.js) (script \"go()\") (link :rel icon :href i.png) (p (title nested))", false,
+			"<html><head></head><body><title>T</title><h1>Hi</h1><meta charset=\"utf-8\"/>" +
+				"<script src=\"a.js\"></script><script>go()</script><link href=\"i.png\" rel=\"icon\"/>" +
+				"<p><title>nested</title></p></body></html>"},
+		{"(title T) (h1 Hi) (meta :charset utf-8) (script :src a.js) (script \"go()\") (link :rel icon :href i.png) (p (title nested))", true,
+			"<html><head><title>T</title><meta charset=\"utf-8\"/><script src=\"a.js\"></script>" +
+				"<link href=\"i.png\" rel=\"icon\"/></head>" +
+				"<body><h1>Hi</h1><script>go()</script><p><title>nested</title></p></body></html>"},
+		{"(head (title T)) (p one) (body.dark (p two)) (meta :name x)", true,
+			"<html><head><title>T</title><meta name=\"x\"/></head>" +
+				"<body class=\"dark\"><p>one</p><p>two</p></body></html>"},
+		{"(html (body (p as-is)))", true,
+			"<html><body><p>as-is</p></body></html>"},
+		{"<!DOCTYPE html>\n(title T) (p x)", true,
+			"<!DOCTYPE html><html><head><title>T</title></head><body><p>x</p></body></html>"},
+	}
+	for _, c := range cases {
+		root, err := ParseWithOptions(c.in, ParseOptions{CapturePrologue: true})
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.in, err)
+		}
+		if got := Scaffold(root, c.hoist).String(); got != c.want {
+			t.Errorf("Scaffold(%q, %v):\n  got: %q\n want: %q", c.in, c.hoist, got, c.want)
+		}
+	}
+
+	if got, want := Scaffold(Element("p", Text("x")), true).String(),
+		"<html><head></head><body><p>x</p></body></html>"; got != want {
+		t.Errorf("Scaffold of an element = %q, want %q", got, want)
+	}
+	if Scaffold(nil, true) != nil {
+		t.Errorf("Scaffold(nil) is not nil")
+	}
+}
@@ -0,0 +1,257 @@
+package htl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// formatWidth is the width FormatSource keeps lines within, where it can.
+const formatWidth = 80
+
+// sourceEscaper backslash-escapes the runes a quoted string cannot hold as is.
+var sourceEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+
+// literalEscaper is sourceEscaper that also escapes the control characters
+// the parser has escape sequences for.
+var literalEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"",
+	"\f", "\\f", "\n", "\\n", "\r", "\\r", "\t", "\\t", "\v", "\\v")
+
+// EscapeString returns s as an htl quoted string, such as "say \"hi\"\n",
+// which Parse reads back as s.  Quotes, backslashes and the control
+// characters \f, \n, \r, \t and \v are backslash-escaped; any other rune is
+// kept as is.
+func EscapeString(s string) string {
+	return "\"" + literalEscaper.Replace(s) + "\""
+}
+
+// UnescapeString returns the string the htl quoted string s stands for, as
+// Parse reads it, undoing EscapeString.  As in Parse, a backslash before a
+// rune with no escape sequence of its own is dropped.
+func UnescapeString(s string) (string, error) {
+	if len(s) < 2 || s[0] != quoteRune || s[len(s)-1] != quoteRune {
+		return "", fmt.Errorf("%q is not a quoted string", s)
+	}
+	var b strings.Builder
+	escaping := false
+	for _, r := range s[1 : len(s)-1] {
+		switch {
+		case escaping:
+			b.WriteString(backslashUnescape(r))
+			escaping = false
+		case r == escapingRune:
+			escaping = true
+		case r == quoteRune:
+			return "", fmt.Errorf("%q holds an unescaped quote", s)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if escaping {
+		return "", fmt.Errorf("%q is not terminated: its last quote is escaped", s)
+	}
+	return b.String(), nil
+}
+
+// HTL returns the htl source of the tree rooted at n, such that parsing it
+// yields a tree Equal to n.  The top-level nodes of a root, as returned by
+// Parse, go on lines of their own; the rest is written on one line.
+//
+// Trees built by hand may hold what no source parses to, such as a raw node
+// outside of raw; those are written as closely as possible.
+func (n *Node) HTL() string {
+	if n == nil {
+		return ""
+	}
+	var b strings.Builder
+	if n.kind == ElementNode && n.tag == "" {
+		for i, c := range n.content {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			if c.kind == RawNode {
+				b.WriteString(c.tag) // a captured prologue.
+				continue
+			}
+			c.writeHTL(&b)
+		}
+		return b.String()
+	}
+	n.writeHTL(&b)
+	return b.String()
+}
+
+// FormatSource returns src in canonical form: each top-level node starts a
+// line, and an element that does not fit on the rest of its line has its
+// children, and its attributes if they do not fit either, each on a line of
+// their own, indented by two more spaces.  Formatting parses src, so the
+// result parses to a tree Equal to that of src, and formatting it again
+// changes nothing.  Since the parser does not keep comments, src holding any
+// is not formatted: the error is ErrComments.
+func FormatSource(src string) (string, error) {
+	root, err := ParseWithOptions(src, ParseOptions{CapturePrologue: true})
+	if err != nil {
+		return "", err
+	}
+	if hasComments(src, root) {
+		return "", ErrComments
+	}
+	if root == nil {
+		return "", nil
+	}
+	var b strings.Builder
+	for _, c := range root.content {
+		if c.kind == RawNode {
+			b.WriteString(c.tag) // a captured prologue.
+		} else {
+			c.writePrettyHTL(&b, 0)
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// ErrComments is the error of FormatSource for source holding comments, which
+// formatting would drop.
+var ErrComments = errors.New("formatting would drop the comments of the source")
+
+// hasComments reports whether src, which parses to root, holds comments: it
+// parses differently with comments off, when any ; starting a token is
+// taken for text instead.
+func hasComments(src string, root *Node) bool {
+	plain, err := ParseWithOptions(src, ParseOptions{CapturePrologue: true, NoComments: true})
+	return err != nil || !plain.Equal(root)
+}
+
+// writePrettyHTL writes the source of n as FormatSource does, for n starting
+// at column indent.
+func (n *Node) writePrettyHTL(b *strings.Builder, indent int) {
+	var line strings.Builder
+	n.writeHTL(&line)
+	if n.kind != ElementNode || n.tag == rawTag || indent+utf8.RuneCountInString(line.String()) <= formatWidth {
+		b.WriteString(line.String())
+		return
+	}
+	pad := "\n" + strings.Repeat(" ", indent+2)
+	head, attrs := n.htlParts()
+	b.WriteString("(" + head)
+	if oneLine := strings.Join(attrs, ""); indent+1+utf8.RuneCountInString(head+oneLine) <= formatWidth {
+		b.WriteString(oneLine)
+	} else {
+		for _, attr := range attrs {
+			b.WriteString(pad + attr[1:])
+		}
+	}
+	for _, c := range n.content {
+		b.WriteString(pad)
+		c.writePrettyHTL(b, indent+2)
+	}
+	b.WriteString(")")
+}
+
+func (n *Node) writeHTL(b *strings.Builder) {
+	switch n.kind {
+	case TextNode:
+		writeHTLString(b, n.tag)
+		return
+	case RawNode:
+		b.WriteString("(raw \"" + sourceEscaper.Replace(n.tag) + "\")")
+		return
+	}
+	if n.tag == rawTag {
+		b.WriteString("(" + rawTag)
+		for _, c := range n.content {
+			b.WriteString(" \"" + sourceEscaper.Replace(c.tag) + "\"")
+		}
+		b.WriteString(")")
+		return
+	}
+
+	head, attrs := n.htlParts()
+	b.WriteString("(" + head + strings.Join(attrs, ""))
+	for _, c := range n.content {
+		b.WriteString(" ")
+		c.writeHTL(b)
+	}
+	b.WriteString(")")
+}
+
+// htlParts returns the parts of the source of element n: its tag with the id
+// and classes in shorthand, if they can be, each other attribute, as
+// " :key value".
+func (n *Node) htlParts() (head string, attrs []string) {
+	head = n.tag
+	shorthand := map[string]bool{}
+	if id, has := n.attr["id"]; has && n.tag != "" && !n.rawAttr["id"] && isShorthandName(id) {
+		head += "#" + id
+		shorthand["id"] = true
+	}
+	if class, has := n.attr["class"]; has && n.tag != "" && !n.rawAttr["class"] && isShorthandClasses(class) {
+		head += "." + strings.Replace(class, " ", ".", -1)
+		shorthand["class"] = true
+	}
+	keys := []string{}
+	for k := range n.attr {
+		if !shorthand[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var attr strings.Builder
+		attr.WriteString(" :" + k + " ")
+		if n.rawAttr[k] {
+			attr.WriteString("(raw \"" + sourceEscaper.Replace(n.attr[k]) + "\")")
+		} else {
+			writeHTLString(&attr, n.attr[k])
+		}
+		attrs = append(attrs, attr.String())
+	}
+	return head, attrs
+}
+
+// writeHTLString writes the text or attribute value s as a symbol if it parses
+// back to s, holding no character reference, and as a quoted string
+// otherwise.
+func writeHTLString(b *strings.Builder, s string) {
+	if isSymbol(s) && unescapeReferences(s) == s {
+		b.WriteString(s)
+		return
+	}
+	b.WriteString("\"" + sourceEscaper.Replace(s) + "\"")
+}
+
+// isShorthandName reports whether s can be written as an id or class in the
+// tag shorthand, as in div#main.wide.
+func isShorthandName(s string) bool {
+	// Inside the tag, the name may start with what a symbol may not.
+	return isSymbol("x"+s) && !strings.ContainsAny(s, "#.") && s != ""
+}
+
+// isShorthandClasses reports whether the space-separated classes s can all be
+// written in the tag shorthand.
+func isShorthandClasses(s string) bool {
+	for _, class := range strings.Split(s, " ") {
+		if !isShorthandName(class) {
+			return false
+		}
+	}
+	return true
+}
+
+// isSymbol reports whether s can be written unquoted, as text or as an
+// attribute value.
+func isSymbol(s string) bool {
+	if s == "" || strings.IndexAny(s[:1], ";:") >= 0 {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || strings.ContainsRune("()\"\\", r) {
+			return false
+		}
+	}
+	return true
+}
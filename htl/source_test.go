@@ -0,0 +1,253 @@
+package htl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestHTL(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"", ""},
+		{"(a :href foo \"x y\") (br)", "(a :href foo \"x y\")\n(br)"},
+		{"(p.a#b \"<&>\" c&d \"&lt;\")", "(p#b.a <&> c&d \"&lt;\")"},
+		{"(p :class \"a  b\" :id \"x y\")", "(p :class \"a  b\" :id \"x y\")"},
+		{"(p :x \"\" :y \":z\" \"a\\\"b\\\\\")", "(p :x \"\" :y \":z\" \"a\\\"b\\\\\")"},
+		{"(p :s (raw \"{'a'}\") (raw \"<b \\\"x\\\">\"))", "(p :s (raw \"{'a'}\") (raw \"<b \\\"x\\\">\"))"},
+		{"(div :style (css :color red :margin 0))", "(div :style color:red;margin:0)"},
+	}
+	for _, c := range cases {
+		tree, err := Parse(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tree.HTL(); got != c.want {
+			t.Errorf("Parse(%q).HTL():\n  got: %q\n want: %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestHTLRoundTrip(t *testing.T) {
+	inputs := []string{
+		"<!DOCTYPE html>\n(html (body (p \"x\")))",
+	}
+	for _, c := range parseCases {
+		if c.want != "" {
+			inputs = append(inputs, c.in)
+		}
+	}
+	for _, in := range inputs {
+		tree, err := ParseWithOptions(in, ParseOptions{CapturePrologue: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := tree.HTL()
+		again, err := ParseWithOptions(src, ParseOptions{CapturePrologue: true})
+		if err != nil {
+			t.Errorf("Parse(%q).HTL() = %q, which fails to parse: %v", in, src, err)
+			continue
+		}
+		if !again.Equal(tree) {
+			t.Errorf("Parse(%q).HTL() = %q, which parses to %q, want %q", in, src, again, tree)
+		}
+	}
+}
+
+func TestFormatSource(t *testing.T) {
+	long := strings.Repeat("x", 70)
+	cases := []struct{ in, want string }{
+		{"", ""},
+		{"(a :href foo \"x y\")   (br)", "(a :href foo \"x y\")\n(br)\n"},
+		{"<!DOCTYPE html>\n(html (body))", "<!DOCTYPE html>\n(html (body))\n"},
+		{"(ul (li " + long + ") (li b))",
+			"(ul\n  (li " + long + ")\n  (li b))\n"},
+		{"(div.c :data-a " + long + " :data-b x (p y))",
+			"(div.c\n  :data-a " + long + "\n  :data-b x\n  (p y))\n"},
+		{"(div :x y (p " + long + "))",
+			"(div :x y\n  (p " + long + "))\n"},
+		{"(div \"a " + long + "\" (p z) :x \"y z\")",
+			"(div :x \"y z\"\n  \"a " + long + "\"\n  (p z))\n"},
+		{"(raw \"" + long + "\" \"" + long + "\")", "(raw \"" + long + "\" \"" + long + "\")\n"},
+	}
+	for _, c := range cases {
+		got, err := FormatSource(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("FormatSource(%q):\n  got: %q\n want: %q", c.in, got, c.want)
+		}
+	}
+	if _, err := FormatSource("(a"); err == nil {
+		t.Errorf("FormatSource(%q) succeeded, want an error", "(a")
+	}
+}
+
+func TestFormatSourceComments(t *testing.T) {
+	for _, in := range []string{
+		"; header comment\n(p a) ; trailing\n",
+		"(p a ;)\n)",
+		"(p :x ; value next\n y)",
+		";",
+	} {
+		if got, err := FormatSource(in); !errors.Is(err, ErrComments) {
+			t.Errorf("FormatSource(%q) = %q, %v; want ErrComments", in, got, err)
+		}
+	}
+	for in, want := range map[string]string{
+		"(p a;b \";\")":      "(p a;b \";\")\n",
+		"(p :style \"x;y\")": "(p :style x;y)\n",
+	} {
+		if got, err := FormatSource(in); err != nil || got != want {
+			t.Errorf("FormatSource(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
+
+func TestFormatSourceIdempotent(t *testing.T) {
+	inputs := []string{
+		"<!DOCTYPE html>\n(html (head (title \"A page\")) (body (div#main.wide (p \"" +
+			strings.Repeat("word ", 30) + "\" (a :href \"/x\" there)))))",
+	}
+	for _, c := range parseCases {
+		if c.want != "" {
+			inputs = append(inputs, c.in)
+		}
+	}
+	for _, in := range inputs {
+		tree, err := ParseWithOptions(in, ParseOptions{CapturePrologue: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, err := FormatSource(in)
+		if errors.Is(err, ErrComments) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("FormatSource(%q): %v", in, err)
+		}
+		if again, err := ParseWithOptions(src, ParseOptions{CapturePrologue: true}); err != nil || !again.Equal(tree) {
+			t.Errorf("FormatSource(%q) = %q, which parses to %v, %v; want %q", in, src, again, err, tree)
+		}
+		if twice, err := FormatSource(src); err != nil || twice != src {
+			t.Errorf("FormatSource(%q) = %q, %v; want it unchanged", src, twice, err)
+		}
+	}
+}
+
+func TestEscapeString(t *testing.T) {
+	cases := []struct{ s, want string }{
+		{"", `""`},
+		{"plain text", `"plain text"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\dir\`, `"C:\\dir\\"`},
+		{"two\nlines\tand\r\f\v", `"two\nlines\tand\r\f\v"`},
+		{"<&> \u00e9 ;(", "\"<&> \u00e9 ;(\""},
+	}
+	for _, c := range cases {
+		got := EscapeString(c.s)
+		if got != c.want {
+			t.Errorf("EscapeString(%q) = %s, want %s", c.s, got, c.want)
+		}
+		if back, err := UnescapeString(got); err != nil || back != c.s {
+			t.Errorf("UnescapeString(%s) = %q, %v; want %q", got, back, err, c.s)
+		}
+		tree, err := Parse("(p " + got + ")")
+		if err != nil {
+			t.Errorf("Parse of %s: %v", got, err)
+			continue
+		}
+		if text := tree.content[0].content[0].tag; text != c.s {
+			t.Errorf("Parse of %s holds %q, want %q", got, text, c.s)
+		}
+	}
+}
+
+func TestUnescapeString(t *testing.T) {
+	if got, err := UnescapeString(`"a\qb"`); err != nil || got != "aqb" {
+		t.Errorf("UnescapeString of an unknown escape = %q, %v; want aqb", got, err)
+	}
+	for _, s := range []string{``, `"`, `abc`, `"abc`, `"a"b"`, `"abc\"`} {
+		if got, err := UnescapeString(s); err == nil {
+			t.Errorf("UnescapeString(%s) = %q, want an error", s, got)
+		}
+	}
+}
+
+func FuzzHTL(f *testing.F) {
+	for _, c := range parseCases {
+		f.Add(c.in)
+	}
+	f.Fuzz(func(t *testing.T, in string) {
+		if !utf8.ValidString(in) {
+			return // Parse replaces invalid bytes, which HTL cannot restore.
+		}
+		tree, err := Parse(in)
+		if err != nil || tree == nil || len(tree.content) == 0 {
+			return
+		}
+		src := tree.HTL()
+		if again, err := Parse(src); err != nil || !again.Equal(tree) {
+			t.Fatalf("Parse(%q).HTL() = %q, which parses to %v, %v", in, src, again, err)
+		}
+	})
+}
+
+func TestEqual(t *testing.T) {
+	a := Element("p", Text("x")).SetAttr("id", "a")
+	cases := []struct {
+		b    *Node
+		want bool
+	}{
+		{Element("p", Text("x")).SetAttr("id", "a"), true},
+		{Element("p", Text("x")).SetAttr("id", "b"), false},
+		{Element("p", Text("x")).SetRawAttr("id", "a"), false},
+		{Element("p", Raw("x")).SetAttr("id", "a"), false},
+		{Element("p").SetAttr("id", "a"), false},
+		{Element("q", Text("x")).SetAttr("id", "a"), false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if got := a.Equal(c.b); got != c.want {
+			t.Errorf("%q.Equal(%q) = %v, want %v", a, c.b, got, c.want)
+		}
+	}
+	if !(*Node)(nil).Equal(nil) {
+		t.Errorf("nil.Equal(nil) = false, want true")
+	}
+}
+
+func TestParseWithSpans(t *testing.T) {
+	in := byteOrderMark + "(p :id x \"a b\" (b c)) tail ; note\n(br)"
+	tree, spans, err := ParseWithSpans(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := tree.content[0]
+	cases := []struct {
+		n    *Node
+		want string
+	}{
+		{tree, in},
+		{p, "(p :id x \"a b\" (b c))"},
+		{p.content[0], "\"a b\""},
+		{p.content[1], "(b c)"},
+		{p.content[1].content[0], "c"},
+		{tree.content[1], "tail"},
+		{tree.content[2], "(br)"},
+	}
+	for _, c := range cases {
+		span, has := spans[c.n]
+		if !has {
+			t.Errorf("no span for %q", c.n)
+			continue
+		}
+		if got := in[span[0]:span[1]]; got != c.want {
+			t.Errorf("span of %q covers %q, want %q", c.n, got, c.want)
+		}
+	}
+	if got, want := len(spans), 7; got != want {
+		t.Errorf("got %d spans, want one per node: %d", got, want)
+	}
+}
@@ -0,0 +1,305 @@
+package htl
+
+import (
+	"strings"
+)
+
+// TreeStats summarizes a tree.
+type TreeStats struct {
+	Elements  int            // Number of elements.
+	TextNodes int            // Number of text and raw nodes.
+	MaxDepth  int            // Greatest number of nested elements.
+	Tags      map[string]int // Number of elements by tag.
+}
+
+// Stats counts the nodes of the tree rooted at n.  Tagless elements, like the
+// root returned by Parse, and raw pseudo-elements are not counted since they
+// do not appear in the html.
+func (n *Node) Stats() TreeStats {
+	stats := TreeStats{Tags: map[string]int{}}
+	n.addStats(&stats, 0)
+	return stats
+}
+
+func (n *Node) addStats(stats *TreeStats, depth int) {
+	if n == nil {
+		return
+	}
+	if n.kind != ElementNode {
+		stats.TextNodes++
+		return
+	}
+	if n.tag != "" && n.tag != rawTag && n.tag != fragmentTag {
+		depth++
+		stats.Elements++
+		stats.Tags[n.tag]++
+		if depth > stats.MaxDepth {
+			stats.MaxDepth = depth
+		}
+	}
+	for _, c := range n.content {
+		c.addStats(stats, depth)
+	}
+}
+
+// blockTags are the elements whose text is set apart on lines of its own by
+// Text, and that Options.IndentBlocks indents.
+var blockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"body": true, "dd": true, "div": true, "dl": true, "dt": true,
+	"figcaption": true, "figure": true, "footer": true, "form": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"head": true, "header": true, "hr": true, "html": true, "li": true,
+	"main": true, "nav": true, "ol": true, "p": true, "pre": true,
+	"section": true, "table": true, "td": true, "th": true, "title": true,
+	"tr": true, "ul": true,
+}
+
+// textlessTags are the elements whose content is not text to a reader.
+var textlessTags = map[string]bool{
+	"script": true, "style": true, "template": true,
+}
+
+// Text returns the text of the tree rooted at n in document order.
+// Block elements such as p and li, and br, start new lines.  The content of
+// script and style elements and of raw is left out, and _ reads as a space.
+func (n *Node) Text() string {
+	var b strings.Builder
+	n.writeText(&b)
+	return strings.TrimSpace(b.String())
+}
+
+func (n *Node) writeText(b *strings.Builder) {
+	if n == nil {
+		return
+	}
+	switch n.kind {
+	case TextNode:
+		if n.tag == "_" {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(n.tag)
+		}
+		return
+	case RawNode:
+		return
+	}
+	if textlessTags[n.tag] || n.tag == rawTag {
+		return
+	}
+	block := blockTags[n.tag]
+	if block || n.tag == "br" {
+		breakLine(b)
+	}
+	for _, c := range n.content {
+		c.writeText(b)
+	}
+	if block {
+		breakLine(b)
+	}
+}
+
+// breakLine ends the line being written to b, unless there is none.
+func breakLine(b *strings.Builder) {
+	if s := b.String(); s != "" && !strings.HasSuffix(s, "\n") {
+		b.WriteByte('\n')
+	}
+}
+
+// Kind returns the type of n.
+func (n *Node) Kind() NodeType {
+	return n.kind
+}
+
+// Tag returns the tag of element n, the unescaped text of a text node, or the
+// html of a raw node.
+func (n *Node) Tag() string {
+	return n.tag
+}
+
+// Attr returns the value of attribute key of n, unescaped unless it is raw,
+// and whether n has it.
+func (n *Node) Attr(key string) (string, bool) {
+	v, has := n.attr[key]
+	return v, has
+}
+
+// ID returns the id attribute of n, or "" if it has none.
+func (n *Node) ID() string {
+	return n.attr["id"]
+}
+
+// SetID sets the id attribute of n and returns n.
+func (n *Node) SetID(id string) *Node {
+	return n.SetAttr("id", id)
+}
+
+// Classes returns the classes in the class attribute of n, in order.
+func (n *Node) Classes() []string {
+	return strings.Fields(n.attr["class"])
+}
+
+// HasClass reports whether class is one of the classes of n.
+func (n *Node) HasClass(class string) bool {
+	for _, c := range n.Classes() {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// AddClass adds class after the classes of n, unless n has it already, and
+// returns n.
+func (n *Node) AddClass(class string) *Node {
+	if n.HasClass(class) {
+		return n
+	}
+	return n.SetAttr("class", strings.Join(append(n.Classes(), class), " "))
+}
+
+// RemoveClass removes class from the classes of n, dropping the class
+// attribute once none is left, and returns n.
+func (n *Node) RemoveClass(class string) *Node {
+	if !n.HasClass(class) {
+		return n
+	}
+	kept := []string{}
+	for _, c := range n.Classes() {
+		if c != class {
+			kept = append(kept, c)
+		}
+	}
+	if len(kept) == 0 {
+		delete(n.attr, "class")
+		n.setRawAttr("class", false)
+		return n
+	}
+	return n.SetAttr("class", strings.Join(kept, " "))
+}
+
+// ChildAt returns the descendant of n reached by following indices, each the
+// index of a child of the node reached so far, or n itself if there are none.
+// It returns nil if an index is out of range.  With String, it serializes just
+// part of a page, as in root.ChildAt(0, 1).String() for the second child of
+// the first element of a parsed root.
+func (n *Node) ChildAt(indices ...int) *Node {
+	for _, i := range indices {
+		if n == nil || i < 0 || i >= len(n.content) {
+			return nil
+		}
+		n = n.content[i]
+	}
+	return n
+}
+
+// Transform rewrites the tree rooted at root bottom-up: the children of each
+// node are transformed first, then the node is replaced by what fn returns for
+// it.  fn may return the node itself, possibly modified, another node, or nil
+// to remove it.  The tree is rewritten in place; the new root is returned.
+func Transform(root *Node, fn func(n *Node) *Node) *Node {
+	if root == nil {
+		return nil
+	}
+	content := root.content[:0]
+	for _, c := range root.content {
+		if c = Transform(c, fn); c != nil {
+			content = append(content, c)
+		}
+	}
+	root.content = content
+	return fn(root)
+}
+
+// Equal reports whether the trees rooted at n and o are the same: nodes of the
+// same kinds and tags, with the same attributes and content.
+func (n *Node) Equal(o *Node) bool {
+	if n == nil || o == nil {
+		return n == o
+	}
+	if n.kind != o.kind || n.tag != o.tag || len(n.attr) != len(o.attr) ||
+		len(n.rawAttr) != len(o.rawAttr) || len(n.content) != len(o.content) {
+		return false
+	}
+	for k, v := range n.attr {
+		if ov, has := o.attr[k]; !has || ov != v || n.rawAttr[k] != o.rawAttr[k] {
+			return false
+		}
+	}
+	for i, c := range n.content {
+		if !c.Equal(o.content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Normalize merges the adjacent text nodes of the tree rooted at n and drops
+// the empty ones, like the DOM method of the same name.  The html the tree
+// is written out as does not change, so the _ nodes standing for &nbsp; are
+// left apart.
+func (n *Node) Normalize() {
+	if n == nil {
+		return
+	}
+	content := n.content[:0]
+	for _, c := range n.content {
+		if c.kind == TextNode && c.tag == "" {
+			continue
+		}
+		if last := len(content) - 1; last >= 0 && mergeableText(content[last]) && mergeableText(c) {
+			content[last] = NewNode(TextNode, content[last].tag+c.tag)
+			continue
+		}
+		c.Normalize()
+		content = append(content, c)
+	}
+	n.content = content
+}
+
+// mergeableText reports whether n is a text node that Normalize may merge.
+func mergeableText(n *Node) bool {
+	return n.kind == TextNode && n.tag != "_"
+}
+
+// Walk calls fn for each node of the tree rooted at n in pre-order: a node
+// before its children, the children in order.  When fn returns false, the
+// children of the node are skipped.
+func (n *Node) Walk(fn func(*Node) bool) {
+	if n == nil || !fn(n) {
+		return
+	}
+	for _, c := range n.content {
+		c.Walk(fn)
+	}
+}
+
+// Find returns the first node of the tree rooted at n, in pre-order, that pred
+// holds for, or nil if there is none.  Tagless elements, like the root
+// returned by Parse, are not offered to pred.
+func (n *Node) Find(pred func(*Node) bool) *Node {
+	var found *Node
+	n.Walk(func(c *Node) bool {
+		if found != nil {
+			return false
+		}
+		if (c.kind != ElementNode || c.tag != "") && pred(c) {
+			found = c
+		}
+		return found == nil
+	})
+	return found
+}
+
+// FindAll returns the nodes of the tree rooted at n that pred holds for, in
+// pre-order.  Like Find, it skips tagless elements.
+func (n *Node) FindAll(pred func(*Node) bool) []*Node {
+	found := []*Node{}
+	n.Walk(func(c *Node) bool {
+		if (c.kind != ElementNode || c.tag != "") && pred(c) {
+			found = append(found, c)
+		}
+		return true
+	})
+	return found
+}
@@ -0,0 +1,257 @@
+package htl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	cases := []struct {
+		in   string
+		want TreeStats
+	}{
+		{"",
+			TreeStats{Tags: map[string]int{}}},
+		{"(a (b (c)))",
+			TreeStats{Elements: 3, MaxDepth: 3, Tags: map[string]int{"a": 1, "b": 1, "c": 1}}},
+		{"(a :x 1 (b :z 2 :y 3 (c \"foo bar\" \"baz\")))",
+			TreeStats{Elements: 3, TextNodes: 2, MaxDepth: 3, Tags: map[string]int{"a": 1, "b": 1, "c": 1}}},
+		{"(ul (li a) (li b (raw \"<br>\"))) (script) (script)",
+			TreeStats{Elements: 5, TextNodes: 3, MaxDepth: 2, Tags: map[string]int{"ul": 1, "li": 2, "script": 2}}},
+		{"(fragment (p a) (<> (p b)))",
+			TreeStats{Elements: 2, TextNodes: 2, MaxDepth: 1, Tags: map[string]int{"p": 2}}},
+	}
+	for _, c := range cases {
+		tree, err := Parse(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tree.Stats(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Parse(%q).Stats() = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"(p \"Tom & \\\"Jerry\\\"\")", "Tom & \"Jerry\""},
+		{"(h1 Title) (p \"One \" (b two) _ three) (p four)",
+			"Title\nOne two three\nfour"},
+		{"(ul (li (a :href /a a)) (li b)) (div \"x\" (br) \"y\")",
+			"a\nb\nx\ny"},
+		{"(head (style \"p {}\") (script \"f()\")) (body (raw \"<b>\") (span hi))",
+			"hi"},
+	}
+	for _, c := range cases {
+		tree, err := Parse(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tree.Text(); got != c.want {
+			t.Errorf("Parse(%q).Text() = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestIDAndClasses(t *testing.T) {
+	root, err := Parse("(div#main.a.b (p :class \"  x   y \"))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	div := root.ChildAt(0)
+	if got := div.ID(); got != "main" {
+		t.Errorf("ID() = %q, want main", got)
+	}
+	if got := div.ChildAt(0).ID(); got != "" {
+		t.Errorf("ID() of an element without one = %q, want empty", got)
+	}
+	if got, want := strings.Join(div.ChildAt(0).Classes(), ","), "x,y"; got != want {
+		t.Errorf("Classes() = %q, want %q", got, want)
+	}
+	if !div.HasClass("a") || div.HasClass("c") || div.HasClass("") {
+		t.Errorf("HasClass: a %v, c %v, empty %v; want true, false, false",
+			div.HasClass("a"), div.HasClass("c"), div.HasClass(""))
+	}
+
+	div.SetID("top").AddClass("c").AddClass("a").RemoveClass("b").RemoveClass("missing")
+	if got, want := div.String(), "<div class=\"a c\" id=\"top\"><p class=\"  x   y \"></p></div>"; got != want {
+		t.Errorf("after SetID, AddClass and RemoveClass:\n  got: %q\n want: %q", got, want)
+	}
+	div.RemoveClass("a").RemoveClass("c")
+	if _, has := div.Attr("class"); has {
+		t.Errorf("class attribute left after removing every class: %q", div.String())
+	}
+	if got, want := Element("p").AddClass("new").String(), "<p class=\"new\"></p>"; got != want {
+		t.Errorf("AddClass to an element without classes = %q, want %q", got, want)
+	}
+}
+
+func TestChildAt(t *testing.T) {
+	root, err := Parse("(html (body (nav (a :href / home)) (main#m (h1 Title) (p \"a & b\"))))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		indices []int
+		want    string
+	}{
+		{nil, root.String()},
+		{[]int{0, 0, 1}, "<main id=\"m\"><h1>Title</h1><p>a &amp; b</p></main>"},
+		{[]int{0, 0, 1, 1}, "<p>a &amp; b</p>"},
+		{[]int{0, 0, 1, 1, 0}, "a &amp; b"},
+	}
+	for _, c := range cases {
+		n := root.ChildAt(c.indices...)
+		if n == nil {
+			t.Errorf("ChildAt(%v) = nil, want %q", c.indices, c.want)
+			continue
+		}
+		if got := n.String(); got != c.want {
+			t.Errorf("ChildAt(%v).String() = %q, want %q", c.indices, got, c.want)
+		}
+	}
+	for _, indices := range [][]int{{1}, {-1}, {0, 0, 2}, {0, 0, 1, 1, 0, 0}} {
+		if n := root.ChildAt(indices...); n != nil {
+			t.Errorf("ChildAt(%v) = %v, want nil", indices, n)
+		}
+	}
+	var nilNode *Node
+	if n := nilNode.ChildAt(0); n != nil {
+		t.Errorf("ChildAt on nil = %v, want nil", n)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	cases := []struct {
+		in   string
+		fn   func(n *Node) *Node
+		want string
+	}{
+		{"(p (img :src a.png) (div (img :src b.png :loading eager)))",
+			func(n *Node) *Node {
+				if n.Kind() == ElementNode && n.Tag() == "img" {
+					if _, has := n.Attr("loading"); !has {
+						n.SetAttr("loading", "lazy")
+					}
+				}
+				return n
+			},
+			"<p><img loading=\"lazy\" src=\"a.png\"/><div><img loading=\"eager\" src=\"b.png\"/></div></p>"},
+		{"(head (script \"a()\") (title t) (script \"b()\"))",
+			func(n *Node) *Node {
+				if n.Tag() == "script" {
+					return nil
+				}
+				return n
+			},
+			"<head><title>t</title></head>"},
+		{"(p (b x) (b y))",
+			func(n *Node) *Node {
+				if n.Tag() == "b" {
+					return Element("strong", n.content...)
+				}
+				return n
+			},
+			"<p><strong>x</strong><strong>y</strong></p>"},
+	}
+	for _, c := range cases {
+		tree, err := Parse(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Transform(tree, c.fn).String(); got != c.want {
+			t.Errorf("Transform(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+	if got := Transform(Element("p"), func(*Node) *Node { return nil }); got != nil {
+		t.Errorf("Transform removing the root = %v, want nil", got)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		in      string
+		content []string // Tags and texts of the children of the first element.
+	}{
+		{"(a \"foo bar\" \"baz\")", []string{"foo barbaz"}},
+		{"(a x \"\" y (b) \"\" z)", []string{"xy", "b", "z"}},
+		{"(a x _ y \"_\" z)", []string{"x", "_", "y", "_", "z"}},
+		{"(a \"\")", []string{}},
+	}
+	for _, c := range cases {
+		tree, err := Parse(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := tree.String()
+		tree.Normalize()
+		if got := tree.String(); got != want {
+			t.Errorf("Normalize changed %q to %q", want, got)
+		}
+		got := []string{}
+		for _, n := range tree.content[0].content {
+			got = append(got, n.tag)
+		}
+		if !reflect.DeepEqual(got, c.content) {
+			t.Errorf("Parse(%q) normalized has children %q, want %q", c.in, got, c.content)
+		}
+	}
+
+	tree, err := Parse("(a (b \"x\" \"y\"))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.Normalize()
+	if got := len(tree.content[0].content[0].content); got != 1 {
+		t.Errorf("Normalize left %d text nodes in a nested element, want 1", got)
+	}
+}
+
+func TestFind(t *testing.T) {
+	tree, err := Parse("(html (head (title a)) (body (div (p x) (title b)) (p y)))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasTag := func(tag string) func(*Node) bool {
+		return func(n *Node) bool { return n.Kind() == ElementNode && n.Tag() == tag }
+	}
+
+	if got := tree.Find(hasTag("title")); got == nil || got.String() != "<title>a</title>" {
+		t.Errorf("Find(title) = %v, want <title>a</title>", got)
+	}
+	if got := tree.Find(hasTag("table")); got != nil {
+		t.Errorf("Find(table) = %v, want nil", got)
+	}
+	if got := tree.Find(hasTag("")); got != nil {
+		t.Errorf("Find matched the root: %v", got)
+	}
+	if got := (*Node)(nil).Find(hasTag("p")); got != nil {
+		t.Errorf("nil.Find = %v, want nil", got)
+	}
+
+	got := []string{}
+	for _, n := range tree.FindAll(hasTag("p")) {
+		got = append(got, n.String())
+	}
+	if want := []string{"<p>x</p>", "<p>y</p>"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FindAll(p) = %q, want %q", got, want)
+	}
+	if got := tree.FindAll(func(n *Node) bool { return n.Kind() == TextNode }); len(got) != 4 {
+		t.Errorf("FindAll(text) found %d nodes, want 4", len(got))
+	}
+
+	visited := []string{}
+	tree.Walk(func(n *Node) bool {
+		visited = append(visited, n.Tag())
+		return n.Tag() != "head"
+	})
+	want := []string{"", "html", "head", "body", "div", "p", "x", "title", "b", "p", "y"}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("Walk visited %q, want %q", visited, want)
+	}
+}
@@ -1,7 +1,16 @@
+// Reads htl from stdin and writes the html it describes to stdout.
+//
+//	$ main <page.htl >page.html
+//	$ main --out=page.html <page.htl
+//	$ main --validate <page.htl  # only report parse errors.
+//	$ main build src dist  # write src/**/*.htl to dist/**/*.html.
+//	$ main fmt page.htl  # rewrite page.htl in canonical form.
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 
@@ -9,15 +18,47 @@ import (
 )
 
 func main() {
-	data, err := ioutil.ReadAll(os.Stdin)
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run is main with its environment passed in.  It returns the exit code: 0 on
+// success, 1 on a parse or I/O error and 2 on a usage error.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "build" {
+		return runBuild(args[1:], stderr)
+	}
+	if len(args) > 0 && args[0] == "fmt" {
+		return runFmt(args[1:], stderr)
+	}
+
+	flags := flag.NewFlagSet("main", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	validate := flags.Bool("validate", false, "Only check the input for parse errors, printing nothing on success.")
+	out := flags.String("out", "", "File to write the html to, instead of stdout.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	data, err := ioutil.ReadAll(stdin)
 	if err != nil {
-		fmt.Printf("error: %v", err)
-		return
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
 	}
 	tree, err := htl.Parse(string(data))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-	} else {
-		fmt.Println(tree)
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if *validate {
+		return 0
+	}
+	if *out == "" {
+		fmt.Fprintln(stdout, tree)
+		return 0
+	}
+	if err := ioutil.WriteFile(*out, []byte(tree.String()+"\n"), 0644); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	cases := []struct {
+		args             []string
+		in               string
+		code             int
+		stdout, inStderr string
+	}{
+		{nil, "(a b)", 0, "<a>b</a>\n", ""},
+		{nil, "(a b", 1, "", "closing parens"},
+		{[]string{"--validate"}, "(a b)", 0, "", ""},
+		{[]string{"--validate"}, "(a b", 1, "", "closing parens"},
+		{[]string{"--no-such-flag"}, "(a b)", 2, "", "no-such-flag"},
+	}
+	for _, c := range cases {
+		var stdout, stderr bytes.Buffer
+		code := run(c.args, strings.NewReader(c.in), &stdout, &stderr)
+		if code != c.code || stdout.String() != c.stdout || !strings.Contains(stderr.String(), c.inStderr) {
+			t.Errorf("run(%q) with input %q = %d, stdout %q, stderr %q; want %d, %q, stderr containing %q",
+				c.args, c.in, code, stdout.String(), stderr.String(), c.code, c.stdout, c.inStderr)
+		}
+	}
+}
+
+func TestRunOut(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.html")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"--out=" + out}, strings.NewReader("(a b)"), &stdout, &stderr); code != 0 {
+		t.Fatalf("run = %d, stderr %q", code, stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("stdout = %q, want nothing", stdout.String())
+	}
+	got, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<a>b</a>\n"; string(got) != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+}
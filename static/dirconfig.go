@@ -0,0 +1,73 @@
+package static
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DirConfigName is the name of the file, in any directory served, overriding
+// the StaticOptions of the files under that directory.  It is never served.
+const DirConfigName = ".ffe.json"
+
+// dirConfig holds the settings of a DirConfigName file, such as
+//
+//	{"indent_html": "  ", "allow_undefined_env": true}
+//
+// Each one given overrides the option of the same name for the files under
+// the directory, subdirectories included, unless one of those gives it again.
+// Options left out keep the value they have for the parent directory.  html
+// is written compactly unless indent_html is set, so "indent_html": "" turns
+// a subtree back to compact output.
+type dirConfig struct {
+	IndentHTML        *string `json:"indent_html"`
+	RawHTL            *bool   `json:"raw_htl"`
+	StreamHTML        *bool   `json:"stream_html"`
+	DevBanner         *bool   `json:"dev_banner"`
+	WarnEmpty         *bool   `json:"warn_empty"`
+	AllowUndefinedEnv *bool   `json:"allow_undefined_env"`
+	MaxInMemoryBytes  *int64  `json:"max_in_memory_bytes"`
+}
+
+// dirOptions returns opts, the options of the parent of directory dir, with
+// the settings of the DirConfigName file in dir applied, if there is one.
+func dirOptions(dir string, opts StaticOptions) (StaticOptions, error) {
+	filename := filepath.Join(dir, DirConfigName)
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return opts, nil
+	}
+	if err != nil {
+		return opts, err
+	}
+	defer f.Close()
+	var c dirConfig
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&c); err != nil {
+		return opts, fmt.Errorf("%s: %v", filename, err)
+	}
+	if c.IndentHTML != nil {
+		opts.IndentHTML = *c.IndentHTML
+	}
+	if c.RawHTL != nil {
+		opts.RawHTL = *c.RawHTL
+	}
+	if c.StreamHTML != nil {
+		opts.StreamHTML = *c.StreamHTML
+	}
+	if c.DevBanner != nil {
+		opts.DevBanner = *c.DevBanner
+	}
+	if c.WarnEmpty != nil {
+		opts.WarnEmpty = *c.WarnEmpty
+	}
+	if c.AllowUndefinedEnv != nil {
+		opts.AllowUndefinedEnv = *c.AllowUndefinedEnv
+	}
+	if c.MaxInMemoryBytes != nil {
+		opts.MaxInMemoryBytes = *c.MaxInMemoryBytes
+	}
+	return opts, nil
+}
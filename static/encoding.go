@@ -0,0 +1,221 @@
+package static
+
+import (
+	"compress/gzip"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// precompressed lists the sibling files, like app.js.br next to app.js, that
+// hold a compressed copy of a file, by preference.
+var precompressed = []struct{ ext, encoding string }{
+	{".br", "br"},
+	{".gz", "gzip"},
+}
+
+// precompressedHandler returns a handler serving a compressed sibling of the
+// file registered at p, if there is one the client accepts, or else h.  Only
+// files whose extension gives their content type qualify, since the type
+// cannot be sniffed from the compressed content.
+func (m *Mux) precompressedHandler(w http.ResponseWriter, r *http.Request, p string, h http.HandlerFunc) http.HandlerFunc {
+	contentType := mime.TypeByExtension(path.Ext(p))
+	if p == "" || contentType == "" {
+		return h
+	}
+	for _, c := range precompressed {
+		sibling, has := m.handlers[p+c.ext]
+		if !has {
+			continue
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsEncoding(r.Header.Get("Accept-Encoding"), c.encoding) {
+			continue
+		}
+		encoding := c.encoding
+		return func(w http.ResponseWriter, r *http.Request) {
+			sibling(&encodedWriter{ResponseWriter: w, contentType: contentType, encoding: encoding}, r)
+		}
+	}
+	return h
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header value lists
+// encoding with a non-zero quality.
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), encoding) {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[len("q="):], 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// encodedWriter serves a compressed resource under the type of its
+// uncompressed content.
+type encodedWriter struct {
+	http.ResponseWriter
+	contentType, encoding string
+	wroteHeader           bool
+}
+
+func (w *encodedWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set("Content-Type", w.contentType)
+		w.Header().Set("Content-Encoding", w.encoding)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *encodedWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// defaultGzipMinSize is the size below which responses are not gzipped, unless
+// StaticOptions.GzipMinSize says otherwise.  Smaller ones gain too little,
+// or even grow.
+const defaultGzipMinSize = 1024
+
+// checkGzip reports an invalid GzipLevel.
+func (opts *StaticOptions) checkGzip() error {
+	if _, err := gzip.NewWriterLevel(nil, opts.gzipLevel()); err != nil {
+		return fmt.Errorf("gzip level %d: %v", opts.GzipLevel, err)
+	}
+	return nil
+}
+
+func (opts *StaticOptions) gzipLevel() int {
+	if opts.GzipLevel == 0 {
+		return gzip.DefaultCompression
+	}
+	return opts.GzipLevel
+}
+
+func (opts *StaticOptions) gzipMinSize() int {
+	if opts.GzipMinSize == 0 {
+		return defaultGzipMinSize
+	}
+	return opts.GzipMinSize
+}
+
+// gzipHandler returns a handler gzipping the responses of h that qualify, as
+// set by opts, if the client accepts gzip.
+func (m *Mux) gzipHandler(w http.ResponseWriter, r *http.Request, h http.HandlerFunc) http.HandlerFunc {
+	if !m.opts.Gzip {
+		return h
+	}
+	if !strings.Contains(strings.Join(w.Header().Values("Vary"), ","), "Accept-Encoding") {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if !acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip") {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		gw := &gzipWriter{ResponseWriter: w, level: m.opts.gzipLevel(), minSize: m.opts.gzipMinSize()}
+		h(gw, r)
+		gw.Close()
+	}
+}
+
+// compressible reports whether content of the given type shrinks when
+// gzipped, unlike images or archives that are compressed already.
+func compressible(contentType string) bool {
+	t := mediaType(contentType)
+	return strings.HasPrefix(t, "text/") || strings.HasSuffix(t, "+xml") || strings.HasSuffix(t, "+json") ||
+		t == "application/javascript" || t == "application/json" || t == "application/xml" ||
+		t == "image/svg+xml"
+}
+
+// gzipWriter holds back the start of a response until it is minSize bytes
+// long, or complete, to decide whether to gzip it.
+type gzipWriter struct {
+	http.ResponseWriter
+	level, minSize int
+
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer // nil unless gzipping.
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.decided {
+		return w.write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.minSize {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *gzipWriter) write(b []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide sends the header, gzipping the response if it is large enough, of a
+// compressible type and not encoded already, then the held back content.
+func (w *gzipWriter) decide() error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if len(w.buf) >= w.minSize && w.status == http.StatusOK && h.Get("Content-Encoding") == "" &&
+		compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz, _ = gzip.NewWriterLevel(w.ResponseWriter, w.level) // the level is checked by NewMux.
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	_, err := w.write(buf)
+	return err
+}
+
+// Close sends what is held back and finishes the gzip stream.
+func (w *gzipWriter) Close() error {
+	if !w.decided {
+		if err := w.decide(); err != nil {
+			return err
+		}
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package static
+
+import (
+	"net/http"
+	"time"
+)
+
+// RequestMetrics describes a request served by a Mux.
+type RequestMetrics struct {
+	Path     string        // The requested path, as sent by the client.
+	Status   int           // The response status code.
+	Bytes    int64         // The size of the response body.
+	Duration time.Duration // The time taken to serve the request.
+}
+
+// metricsWriter records the status and size of a response.
+type metricsWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *metricsWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *metricsWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// serveWithMetrics serves r with h, then reports the request to report.
+func serveWithMetrics(h http.Handler, w http.ResponseWriter, r *http.Request, report func(RequestMetrics)) {
+	start := time.Now()
+	mw := &metricsWriter{ResponseWriter: w}
+	h.ServeHTTP(mw, r)
+	if mw.status == 0 {
+		mw.status = http.StatusOK // nothing was written.
+	}
+	report(RequestMetrics{
+		Path:     r.URL.Path,
+		Status:   mw.status,
+		Bytes:    mw.bytes,
+		Duration: time.Since(start),
+	})
+}
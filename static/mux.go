@@ -0,0 +1,535 @@
+package static
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/honr/vulcan/htl"
+)
+
+// StaticOptions configures a Mux.
+type StaticOptions struct {
+	// Dev rereads registered resources on each request.
+	Dev bool
+
+	// Debug sends the reason a resource failed to load, such as a parse
+	// error, to the client along with the 500 response.
+	Debug bool
+
+	// Index is the file served for a directory, for instance /index.html.  The
+	// root directory serves Index itself; subdirectories serve their file of
+	// the same base name.
+	Index string
+
+	// Listing renders an html index of a directory lacking an index file.  It
+	// is off by default, since it reveals every file under the directories.
+	Listing bool
+
+	// AllowSniffing omits the "X-Content-Type-Options: nosniff" header that is
+	// otherwise sent with every response.
+	AllowSniffing bool
+
+	// FrameOptions, if set, is sent as the X-Frame-Options header, for
+	// instance DENY or SAMEORIGIN.
+	FrameOptions string
+
+	// ContentSecurityPolicy, if set, is sent verbatim as the
+	// Content-Security-Policy header.
+	ContentSecurityPolicy string
+
+	// RedirectToCanonical redirects, with 301 Moved Permanently, requests for
+	// an existing resource by any other path than its canonical one: without
+	// repeated slashes or dot segments, and ending in a slash exactly when it
+	// names a directory.  Requests for missing resources are never redirected,
+	// so redirects cannot loop.
+	RedirectToCanonical bool
+
+	// ServeDotfiles serves files whose names start with a dot, and those in
+	// such directories, like .git.  They are skipped by default.
+	ServeDotfiles bool
+
+	// Ignore lists glob patterns, as for path.Match, of files and directories
+	// to skip, like *.tmp.  A pattern holding a slash is matched against the
+	// path under the directory, like drafts/*.htl; any other against the base
+	// name alone, at any depth.  A directory that matches is skipped whole.
+	Ignore []string
+
+	// WarnEmpty logs a warning when an htl file yields an empty document, as
+	// one holding only whitespace or comments does, which is likely a
+	// mistake.  A file of zero bytes is taken to be empty on purpose.
+	WarnEmpty bool
+
+	// StreamHTML keeps the tree parsed from each htl file, rather than the
+	// html it yields, and writes the html out to each client as it goes.
+	// This saves holding the html of large pages in memory, at the cost of
+	// serializing it on each request.  Dev mode rereads files anyway.
+	StreamHTML bool
+
+	// IndentHTML, unless empty, writes the html of htl files with each block
+	// element on a line of its own, indented by IndentHTML, as
+	// htl.Options.IndentBlocks does, to read in the page source.  Otherwise
+	// it is written as compactly as htl allows.
+	IndentHTML string
+
+	// RawHTL serves htl files as they are, as text/plain, rather than the html
+	// they yield, at the same .html paths, to debug their source.  Their
+	// {{env:NAME}} placeholders are left as written.
+	RawHTL bool
+
+	// DevBanner, in dev mode, adds a small banner reading "dev build" to a
+	// corner of each page rendered from htl, so that a dev server is not
+	// mistaken for production.  Outside dev mode it has no effect.
+	DevBanner bool
+
+	// SourceHeader, in dev mode, sends the absolute path of the file each
+	// response is read from as the X-Ffe-Source header, to tell which of
+	// several directories a page comes from.  Outside dev mode it has no
+	// effect, so the layout of the server never leaks in production.
+	SourceHeader bool
+
+	// CaseInsensitive matches request paths to files regardless of case, as
+	// case-insensitive filesystems do, so About.html is served at
+	// /about.html too.  Paths are registered in lowercase; two files whose
+	// paths differ only by case are logged, and the latter one wins unless
+	// StrictDuplicates makes that an error.
+	CaseInsensitive bool
+
+	// AllowUndefinedEnv fills in {{env:NAME}} placeholders in htl files,
+	// which are replaced by the value of the environment variable NAME when
+	// the file is read, with "" when NAME is unset.  Otherwise reading the
+	// file fails.
+	AllowUndefinedEnv bool
+
+	// StrictDuplicates makes NewMux fail when two files would be served at the
+	// same path, rather than letting the one in the latter directory win.
+	StrictDuplicates bool
+
+	// ContinueOnError logs each file that fails to load, such as an htl file
+	// that does not parse, and serves the others, rather than failing to load
+	// any.  The failed files are not served.  Dev mode loads them on each
+	// request anyway.
+	ContinueOnError bool
+
+	// MaxInMemoryBytes, if positive, is the size of the largest file read
+	// into memory.  Larger files, like a log left in a served directory, are
+	// logged and skipped, and in dev mode fail to load once they grow larger.
+	MaxInMemoryBytes int64
+
+	// Fallbacks maps path prefixes, like /app/, to the path of the resource
+	// served, like /app/index.html, for any path under the prefix that
+	// nothing else serves, as single page apps routing on the client need.
+	// The longest matching prefix wins.  Real files are never shadowed.
+	Fallbacks map[string]string
+
+	// Gzip compresses responses on the fly for clients accepting gzip, when
+	// they are text, like html, css or javascript, of GzipMinSize bytes or
+	// more, and no precompressed sibling is served instead.
+	Gzip bool
+
+	// GzipLevel is the gzip compression level, from gzip.BestSpeed to
+	// gzip.BestCompression.  Zero means gzip.DefaultCompression.
+	GzipLevel int
+
+	// GzipMinSize is the size, in bytes, below which responses are sent
+	// uncompressed.  Zero means 1024.
+	GzipMinSize int
+
+	// Headers maps glob patterns, matched as those of Ignore are, to headers
+	// sent with the resources at matching paths, such as {"*.wasm":
+	// {"Cross-Origin-Embedder-Policy": {"require-corp"}}}.  The path matched
+	// is that of the resource served, like /index.html for /.  When several
+	// patterns match, their headers are sent in the order of the patterns,
+	// sorted, a later one replacing a header of an earlier one.  Headers the
+	// Mux sets itself, like Content-Type, are best left alone.
+	Headers map[string]http.Header
+
+	// Metrics, if set, is called after each request with what was served, for
+	// instance to feed Prometheus counters.
+	Metrics func(RequestMetrics)
+}
+
+// Mux serves the resources found under a set of directories.  A file is
+// served at its name as it is, unescaped, matched against the request path
+// as net/http decodes it: my file.html at /my%20file.html and 100%.txt at
+// /100%25.txt.  The links a Mux writes itself, in listings, sitemaps and
+// redirects, are percent-encoded.
+type Mux struct {
+	dirs     []string
+	opts     StaticOptions
+	handlers map[string]http.HandlerFunc
+	sources  map[string]string // File each path is served from.
+}
+
+// NewMux walks dirs and registers a handler for each file found.  When several
+// directories hold a file at the same path, the one in the latter directory
+// wins, unless opts.StrictDuplicates makes that an error.  A DirConfigName
+// file in any directory overrides some of opts for the files under it.
+func NewMux(dirs []string, opts StaticOptions) (*Mux, error) {
+	if err := opts.checkGzip(); err != nil {
+		return nil, err
+	}
+	if err := opts.checkHeaders(); err != nil {
+		return nil, err
+	}
+	handlers, sources, err := handlersFromDirs(dirs, opts)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mux{dirs: dirs, opts: opts, handlers: handlers, sources: sources}
+	for prefix, target := range opts.Fallbacks {
+		if _, has := handlers[m.key(target)]; !has {
+			return nil, fmt.Errorf("fallback for %s: nothing is served at %s", prefix, target)
+		}
+	}
+	return m, nil
+}
+
+// NewFileMux serves the resources transformed from the single file filename:
+// the main one at / and each one at the base name of filename, with its
+// suffix if any, as a Mux of its parent directory would.
+func NewFileMux(filename string, opts StaticOptions) (*Mux, error) {
+	if err := opts.checkGzip(); err != nil {
+		return nil, err
+	}
+	if err := opts.checkHeaders(); err != nil {
+		return nil, err
+	}
+	handlers, err := handlerFuncsFromFile(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mux{opts: opts, handlers: map[string]http.HandlerFunc{}, sources: map[string]string{}}
+	p := "/" + filepath.Base(filename)
+	for i, sh := range handlers {
+		paths := []string{resourcePath(p, sh.suffix)}
+		if i == 0 {
+			paths = append(paths, "/")
+		}
+		for _, rp := range paths {
+			m.handlers[m.key(rp)] = sh.h
+			m.sources[m.key(rp)] = filename
+		}
+	}
+	return m, nil
+}
+
+// Handle registers a resource built by the caller, such as a generated
+// sitemap, at path p.  It replaces anything registered at p before, and is not
+// safe to call while m is serving.
+func (m *Mux) Handle(p string, r *Resource) {
+	m.handlers[m.key(p)] = resourceHandlerFunc(r)
+	delete(m.sources, m.key(p))
+}
+
+// Source returns the file the resource at path p is read from, or "" if p is
+// not registered or was registered with Handle.
+func (m *Mux) Source(p string) string {
+	return m.sources[m.key(p)]
+}
+
+// Paths returns the registered paths, sorted.
+func (m *Mux) Paths() []string {
+	paths := make([]string, 0, len(m.handlers))
+	for p := range m.handlers {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.opts.Metrics != nil {
+		serveWithMetrics(http.HandlerFunc(m.serve), w, r, m.opts.Metrics)
+		return
+	}
+	m.serve(w, r)
+}
+
+func (m *Mux) serve(w http.ResponseWriter, r *http.Request) {
+	m.setSecurityHeaders(w.Header())
+	p := path.Clean("/" + r.URL.Path)
+	h, file, isDir := m.handler(p)
+	if h == nil {
+		if target := m.htlAlias(p); target != "" {
+			u := *r.URL
+			u.Path, u.RawPath = target, ""
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+		if h, file = m.fallback(p); h == nil {
+			http.NotFound(w, r)
+			return
+		}
+	} else if m.opts.RedirectToCanonical {
+		canonical := p
+		if isDir && p != "/" {
+			canonical += "/"
+		}
+		if canonical != r.URL.Path {
+			u := *r.URL
+			u.Path, u.RawPath = canonical, ""
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+	}
+	if file != "" {
+		m.opts.setHeaders(w.Header(), file)
+		m.setSourceHeader(w.Header(), file)
+	} else {
+		m.opts.setHeaders(w.Header(), p)
+	}
+	h = m.precompressedHandler(w, r, file, h)
+	h = m.gzipHandler(w, r, h)
+	h(w, r)
+}
+
+// handler returns the handler for the cleaned path p, or nil if there is none,
+// the path it is registered at ("" for a listing) and whether p names a
+// directory.
+func (m *Mux) handler(p string) (h http.HandlerFunc, file string, isDir bool) {
+	if h, has := m.handlers[m.key(p)]; has {
+		return h, m.key(p), false
+	}
+	if index := m.key(m.indexFor(p)); index != "" {
+		if h, has := m.handlers[index]; has {
+			return h, index, true
+		}
+	}
+	if m.opts.Listing {
+		if names, found := m.list(p); found {
+			return func(w http.ResponseWriter, r *http.Request) {
+				writeListing(w, p, names)
+			}, "", true
+		}
+	}
+	return nil, "", false
+}
+
+// htlAlias returns the path the html of the htl file at the cleaned path p is
+// served at, for links made when htl files were served at their own paths, or
+// "" if there is no such file.
+func (m *Mux) htlAlias(p string) string {
+	if path.Ext(p) != ".htl" {
+		return ""
+	}
+	target := resourcePath(p, ".html")
+	if filepath.Ext(m.sources[m.key(target)]) != ".htl" {
+		return ""
+	}
+	return target
+}
+
+// fallback returns the handler of the fallback for the cleaned path p, if any,
+// and the path it is registered at.
+func (m *Mux) fallback(p string) (h http.HandlerFunc, file string) {
+	prefix, p := "", m.key(p)
+	for pre := range m.opts.Fallbacks {
+		if len(pre) > len(prefix) && (strings.HasPrefix(p, m.key(pre)) || p+"/" == m.key(pre)) {
+			prefix = pre
+		}
+	}
+	if prefix == "" {
+		return nil, ""
+	}
+	file = m.key(m.opts.Fallbacks[prefix])
+	return m.handlers[file], file
+}
+
+// key returns the key of path p in m.handlers: p itself, or lowercased if
+// opts.CaseInsensitive.
+func (m *Mux) key(p string) string {
+	return m.opts.pathKey(p)
+}
+
+func (opts *StaticOptions) pathKey(p string) string {
+	if opts.CaseInsensitive {
+		return strings.ToLower(p)
+	}
+	return p
+}
+
+// setSourceHeader sets X-Ffe-Source to the file the resource at key is read
+// from, if opts.SourceHeader asks in dev mode.
+func (m *Mux) setSourceHeader(h http.Header, key string) {
+	filename := m.sources[key]
+	if !m.opts.Dev || !m.opts.SourceHeader || filename == "" {
+		return
+	}
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	h.Set("X-Ffe-Source", filename)
+}
+
+func (m *Mux) setSecurityHeaders(h http.Header) {
+	if !m.opts.AllowSniffing {
+		h.Set("X-Content-Type-Options", "nosniff")
+	}
+	if m.opts.FrameOptions != "" {
+		h.Set("X-Frame-Options", m.opts.FrameOptions)
+	}
+	if m.opts.ContentSecurityPolicy != "" {
+		h.Set("Content-Security-Policy", m.opts.ContentSecurityPolicy)
+	}
+}
+
+// checkIgnore reports the first malformed pattern of Ignore.
+func (opts *StaticOptions) checkIgnore() error {
+	for _, pattern := range opts.Ignore {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("ignore pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// checkHeaders reports the first malformed pattern of Headers.
+func (opts *StaticOptions) checkHeaders() error {
+	for pattern := range opts.Headers {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("headers pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// setHeaders sets in h the Headers of the patterns matching path p.
+func (opts *StaticOptions) setHeaders(h http.Header, p string) {
+	if len(opts.Headers) == 0 {
+		return
+	}
+	patterns := make([]string, 0, len(opts.Headers))
+	for pattern := range opts.Headers {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if !matchPath(pattern, strings.TrimPrefix(p, "/")) {
+			continue
+		}
+		for k, vs := range opts.Headers[pattern] {
+			h.Del(k)
+			for _, v := range vs {
+				h.Add(k, v)
+			}
+		}
+	}
+}
+
+// matchPath reports whether the slash-separated path p, without a leading
+// slash, matches pattern: as a whole if pattern holds a slash, and by its base
+// name otherwise.
+func matchPath(pattern, p string) bool {
+	subject := path.Base(p)
+	if strings.Contains(pattern, "/") {
+		subject = p
+	}
+	matched, _ := path.Match(strings.TrimPrefix(pattern, "/"), subject)
+	return matched
+}
+
+// ignored reports whether the file or directory at slash-separated path p,
+// relative to its directory, is to be skipped.
+func (opts *StaticOptions) ignored(p string) bool {
+	p = strings.TrimPrefix(p, "/")
+	name := path.Base(p)
+	if !opts.ServeDotfiles && strings.HasPrefix(name, ".") {
+		return true
+	}
+	for _, pattern := range opts.Ignore {
+		if matchPath(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredPath reports whether the slash-separated path p, or any directory
+// it is in, is to be skipped, as walking the directories would.
+func (opts *StaticOptions) ignoredPath(p string) bool {
+	segments := strings.Split(strings.Trim(p, "/"), "/")
+	for i := range segments {
+		if segments[i] != "" && opts.ignored(strings.Join(segments[:i+1], "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexFor returns the path of the index file of directory p, or "" if no
+// index is configured.
+func (m *Mux) indexFor(p string) string {
+	if m.opts.Index == "" {
+		return ""
+	}
+	if p == "/" {
+		return m.opts.Index
+	}
+	return path.Join(p, path.Base(m.opts.Index))
+}
+
+// list returns the sorted names in directory p merged across all dirs, with a
+// trailing slash for subdirectories, and whether p was a directory in any of
+// them.  A file is named as it is served, like page.html for page.htl.  A
+// directory that is skipped, or is in one that is, is not found.
+func (m *Mux) list(p string) (names []string, found bool) {
+	if m.opts.ignoredPath(p) {
+		return nil, false
+	}
+	seen := map[string]bool{}
+	for _, dir := range m.dirs {
+		infos, err := ioutil.ReadDir(filepath.Join(dir, filepath.FromSlash(p)))
+		if err != nil {
+			continue
+		}
+		found = true
+		for _, info := range infos {
+			name := info.Name()
+			if m.opts.ignored(path.Join(p, name)) {
+				continue
+			}
+			if info.IsDir() {
+				name += "/"
+			} else {
+				name = resourcePath(name, outExts[path.Ext(name)])
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, found
+}
+
+// writeListing writes an html page linking to names, the entries of directory
+// p.
+func writeListing(w http.ResponseWriter, p string, names []string) {
+	if p != "/" {
+		names = append([]string{"../"}, names...)
+	}
+	items := []*htl.Node{}
+	for _, name := range names {
+		href := path.Join(p, name)
+		if strings.HasSuffix(name, "/") && href != "/" {
+			href += "/"
+		}
+		items = append(items, htl.Element("li",
+			htl.Element("a", htl.Text(name)).SetAttr("href", (&url.URL{Path: href}).String())))
+	}
+	title := "Index of " + p
+	page := htl.Element("html",
+		htl.Element("head", htl.Element("title", htl.Text(title))),
+		htl.Element("body", htl.Element("h1", htl.Text(title)), htl.Element("ul", items...)))
+
+	w.Header().Add("Content-Type", mime.TypeByExtension(".html"))
+	w.Write([]byte(page.String()))
+}
@@ -0,0 +1,64 @@
+package static
+
+import (
+	"strconv"
+	"strings"
+)
+
+// sourceMediaTypes lists the media types a client may ask for to get the htl
+// source of a resource rather than its html, with the content type each one
+// is served as.
+var sourceMediaTypes = []struct{ mediaType, contentType string }{
+	{"application/htl", "application/htl"},
+	{"text/plain", "text/plain; charset=utf-8"},
+}
+
+// sourceType returns the content type to serve the htl source of a resource
+// as, if the Accept header value names one of sourceMediaTypes and prefers it
+// to text/html, or "" to serve the html.  Wildcards never select the source.
+func sourceType(accept string) string {
+	html, _ := acceptQuality(accept, "text/html")
+	best, contentType := 0.0, ""
+	for _, t := range sourceMediaTypes {
+		if q, exact := acceptQuality(accept, t.mediaType); exact && q > html && q > best {
+			best, contentType = q, t.contentType
+		}
+	}
+	return contentType
+}
+
+// acceptQuality returns the quality an Accept header value gives
+// mediaType, taken from the most specific range matching it, and whether that
+// range names mediaType itself.  An empty header accepts everything.
+func acceptQuality(accept, mediaType string) (q float64, exact bool) {
+	if strings.TrimSpace(accept) == "" {
+		return 1, false
+	}
+	major := strings.SplitN(mediaType, "/", 2)[0] + "/*"
+	specificity := -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		s := -1
+		switch r := strings.ToLower(strings.TrimSpace(params[0])); r {
+		case mediaType:
+			s = 2
+		case major:
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[len("q="):], 64); err == nil {
+					q = v
+				}
+			}
+		}
+	}
+	return q, specificity == 2
+}
@@ -0,0 +1,64 @@
+package static
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// shutdownTimeout is how long requests in flight have to finish once a
+// server is told to stop.
+const shutdownTimeout = 5 * time.Second
+
+// Serve serves h on the TCP address addr until ctx is done, for a program
+// that runs the server among other things, then shuts it down as
+// ServeListener does, along with background.
+func Serve(ctx context.Context, addr string, h http.Handler, background ...func(context.Context)) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return ServeListener(ctx, &http.Server{Addr: addr, Handler: h}, l, background...)
+}
+
+// ServeListener serves s on l until ctx is done, then shuts s down, closing l,
+// and waits up to five seconds for the requests in flight to finish.  It
+// returns once s has stopped: with nil after a shutdown in time, and otherwise
+// with the error that stopped it.
+//
+// Each of background, such as a watcher of the files served, runs in a
+// goroutine of its own for as long as s does.  The context it is given is
+// done once s stops, whether or not ctx is, and ServeListener waits for it to
+// return before returning itself.
+func ServeListener(ctx context.Context, s *http.Server, l net.Listener, background ...func(context.Context)) error {
+	ctx, stop := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, f := range background {
+		wg.Add(1)
+		go func(f func(context.Context)) {
+			defer wg.Done()
+			f(ctx)
+		}(f)
+	}
+	defer wg.Wait()
+	defer stop()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := s.Shutdown(shutdownCtx)
+	if serveErr := <-served; err == nil && serveErr != http.ErrServerClosed {
+		err = serveErr
+	}
+	return err
+}
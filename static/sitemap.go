@@ -0,0 +1,84 @@
+package static
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// sitemapNS is the namespace of the sitemap protocol, see sitemaps.org.
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	NS      string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+// AddSitemap registers a /sitemap.xml listing the html pages m serves, under
+// baseURL, such as https://example.com, and a /robots.txt allowing every
+// page and pointing at the sitemap.  Index files are listed by the path of
+// their directory.  Files registered at either path win over the generated
+// ones.  Like Handle, it is not safe to call while m is serving.
+func (m *Mux) AddSitemap(baseURL string) error {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return fmt.Errorf("sitemap: %v", err)
+	}
+	if !base.IsAbs() || base.Host == "" {
+		return fmt.Errorf("sitemap: base URL %q is not absolute", baseURL)
+	}
+
+	if _, has := m.handlers["/sitemap.xml"]; !has {
+		sitemap, err := m.sitemap(base)
+		if err != nil {
+			return err
+		}
+		m.Handle("/sitemap.xml", &Resource{ContentType: mime.TypeByExtension(".xml"), Content: sitemap})
+	}
+	if _, has := m.handlers["/robots.txt"]; !has {
+		robots := fmt.Sprintf("User-agent: *\nAllow: /\nSitemap: %s\n", base.String()+"/sitemap.xml")
+		m.Handle("/robots.txt", &Resource{ContentType: "text/plain; charset=utf-8", Content: []byte(robots)})
+	}
+	return nil
+}
+
+// sitemap returns the sitemap of the html pages m serves, under base.
+func (m *Mux) sitemap(base *url.URL) ([]byte, error) {
+	set := sitemapURLSet{NS: sitemapNS}
+	seen := map[string]bool{}
+	for _, p := range m.Paths() {
+		if !isHTMLPath(p) {
+			continue
+		}
+		if m.opts.Index != "" && path.Base(p) == path.Base(m.opts.Index) {
+			p = strings.TrimSuffix(path.Dir(p), "/") + "/"
+		}
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		u := *base
+		u.Path += p
+		set.URLs = append(set.URLs, sitemapURL{Loc: u.String()})
+	}
+	b, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("sitemap: %v", err)
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+// isHTMLPath reports whether the resource registered at p is an html page,
+// either an html file or one transformed from htl.
+func isHTMLPath(p string) bool {
+	ext := path.Ext(p)
+	return sourceTypes[ext] == htlContentType || mediaType(mime.TypeByExtension(ext)) == "text/html"
+}
@@ -1,94 +1,499 @@
 package static
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"fmt"
+	"hash"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"log"
 	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/honr/vulcan/htl"
 )
 
 type Resource struct {
 	ContentType string
-	Content []byte
+	Content     []byte
+
+	// Suffix, if set, replaces the extension of the source file in the path
+	// the resource is served at.  Resources produced by the same transformer
+	// need distinct suffixes.
+	Suffix string
+
+	// Source, if set, is the htl the resource was transformed from, served
+	// instead to clients that ask for text/plain or application/htl over
+	// text/html.
+	Source *Resource
+
+	// Tree, if set, is written out as html in place of Content, streaming it
+	// to each client rather than keeping it serialized in memory.
+	Tree *htl.Node
+
+	// ModTime, if set, is when the file the resource was read from was last
+	// modified.  It is sent as Last-Modified, and a request whose
+	// If-Modified-Since is no earlier gets 304 Not Modified.
+	ModTime time.Time
+
+	indent string // StaticOptions.IndentHTML, for writing out Tree.
 }
 
-func htlToHTML(r *Resource) error {
-	n, err := htl.Parse(string(r.Content))
-	if err != nil {
-		return err
+var integrityHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// IntegrityHash returns the subresource integrity digest of r's content, such
+// as "sha384-...", for use in the integrity attribute of the script or link
+// elements referencing r.  algo is one of sha256, sha384 and sha512; any other
+// yields "".
+func (r *Resource) IntegrityHash(algo string) string {
+	newHash, has := integrityHashes[algo]
+	if !has {
+		return ""
+	}
+	h := newHash()
+	r.writeContent(h)
+	return algo + "-" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// writeContent writes the content of r to w, serializing r.Tree if set.
+func (r *Resource) writeContent(w io.Writer) (int64, error) {
+	if r.Tree != nil {
+		return r.Tree.FormatTo(w, htl.Options{IndentBlocks: r.indent})
+	}
+	n, err := w.Write(r.Content)
+	return int64(n), err
+}
+
+// htlContentType is the content type of htl sources.
+const htlContentType = "text/x-htl"
+
+// rawHTLContentType is the type htl is served as with StaticOptions.RawHTL,
+// which browsers show rather than download.
+const rawHTLContentType = "text/plain; charset=utf-8"
+
+// sourceTypes gives the content types of the source files whose extensions
+// the mime package does not know.
+var sourceTypes = map[string]string{
+	".htl": htlContentType,
+}
+
+func htlToHTML(r *Resource) ([]*Resource, error) {
+	return htlTransformer(StaticOptions{})(r)
+}
+
+// htlTransformer returns the transformer of htl to html for opts.  It expands
+// the components the file defines, fills in the {{env:NAME}} placeholders
+// from the environment, and with opts.StreamHTML keeps the parsed tree, to be
+// written out on each request, instead of its serialization.
+func htlTransformer(opts StaticOptions) func(*Resource) ([]*Resource, error) {
+	return func(r *Resource) ([]*Resource, error) {
+		n, err := htl.Parse(string(r.Content))
+		if err != nil {
+			return nil, err
+		}
+		if n, err = htl.ExpandComponents(n); err != nil {
+			return nil, err
+		}
+		if err := htl.ExpandEnv(n, opts.lookupEnv); err != nil {
+			return nil, err
+		}
+		if opts.Dev && opts.DevBanner && n.ChildAt(0) != nil {
+			addDevBanner(n)
+		}
+		r.ContentType = mime.TypeByExtension(".html")
+		if opts.StreamHTML {
+			r.Content, r.Tree, r.indent = nil, n, opts.IndentHTML
+		} else {
+			r.Content = []byte(n.Format(htl.Options{IndentBlocks: opts.IndentHTML}))
+		}
+		return []*Resource{r}, nil
 	}
-	r.ContentType = mime.TypeByExtension(".html")
-	r.Content = []byte(n.String())
-	return nil
 }
 
-var transformers = map[string]func(*Resource)error {
-	".htl": htlToHTML,
+// devBannerStyle keeps the banner of StaticOptions.DevBanner in a corner, over
+// the page, without catching clicks.
+const devBannerStyle = "position:fixed;bottom:0;right:0;z-index:2147483647;padding:2px 6px;" +
+	"font:12px sans-serif;background:#fc0;color:#000;pointer-events:none"
+
+// addDevBanner adds the banner of StaticOptions.DevBanner to the end of the
+// body of the document root, or to the end of root if it has no body.
+func addDevBanner(root *htl.Node) {
+	banner := htl.Element("div", htl.Text("dev build")).
+		SetAttr("id", "ffe-dev-banner").SetAttr("style", devBannerStyle)
+	body := root.Find(func(n *htl.Node) bool {
+		return n.Kind() == htl.ElementNode && n.Tag() == "body"
+	})
+	if body == nil {
+		body = root
+	}
+	body.Append(banner)
+}
+
+// lookupEnv looks up an environment variable for htl.ExpandEnv, finding unset
+// ones empty if opts.AllowUndefinedEnv.
+func (opts StaticOptions) lookupEnv(name string) (string, bool) {
+	v, has := os.LookupEnv(name)
+	return v, has || opts.AllowUndefinedEnv
 }
 
+// transformers turn the resource read from a file, keyed by the file's
+// extension, into one or more resources to serve.  The first one is the main
+// output.  They may be called concurrently, for different files.
+var transformers = map[string]func(*Resource) ([]*Resource, error){}
+
+// outExts maps the extensions given to RegisterTransformer to the extensions
+// the main outputs of their files are served at.
+var outExts = map[string]string{}
+
+// RegisterTransformer registers f to transform the files whose extension is
+// ext, like ".scss", in place of any transformer registered for it before.
+// If outExt is not empty, like ".css", the main output of f is served at the
+// path of the file with its extension replaced by outExt, as if f had set
+// its Suffix, unless f did.  Register transformers before loading any files,
+// as from an init function.
+func RegisterTransformer(ext string, f func(*Resource) ([]*Resource, error), outExt string) {
+	outExts[ext] = outExt
+	if outExt == "" {
+		transformers[ext] = f
+		return
+	}
+	transformers[ext] = func(r *Resource) ([]*Resource, error) {
+		resources, err := f(r)
+		if err == nil && len(resources) > 0 && resources[0].Suffix == "" {
+			resources[0].Suffix = outExt
+		}
+		return resources, err
+	}
+}
+
+func init() {
+	// htl is turned to html by its media type, below; this only serves the
+	// html at .html paths.  The Mux redirects the .htl ones there.
+	RegisterTransformer(".htl", func(r *Resource) ([]*Resource, error) {
+		return []*Resource{r}, nil
+	}, ".html")
+}
+
+// typeTransformers turn a resource, keyed by its media type, into one or more
+// resources the same way.  They run after transformers, in a pipeline: as long
+// as one matches the type of the main output, it transforms that output
+// again, so a markdown transformer need only produce htl to yield html.
+var typeTransformers = map[string]func(*Resource) ([]*Resource, error){
+	htlContentType: htlToHTML,
+}
+
+// mediaType returns contentType without its parameters, such as charset.
+func mediaType(contentType string) string {
+	return strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+}
+
+// ResourceFromFile returns the main resource transformed from filename.
 func ResourceFromFile(filename string) (*Resource, error) {
-	content, err := ioutil.ReadFile(filename)
+	resources, err := ResourcesFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return resources[0], nil
+}
+
+// ResourcesFromFile returns all resources transformed from filename, the main
+// one first.
+func ResourcesFromFile(filename string) ([]*Resource, error) {
+	return resourcesFromFile(filename, StaticOptions{})
+}
+
+// resourcesFromFile is ResourcesFromFile, also logging when opts.WarnEmpty
+// asks and an htl file yields an empty document.
+func resourcesFromFile(filename string, opts StaticOptions) ([]*Resource, error) {
+	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if opts.tooLarge(info) {
+		return nil, fmt.Errorf("%s: %d bytes is over the limit of %d", filename, info.Size(), opts.MaxInMemoryBytes)
+	}
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return transformContent(filename, content, info.ModTime(), opts)
+}
+
+// resourcesFromFS is resourcesFromFile for the file at name in fsys.
+func resourcesFromFS(fsys fs.FS, name string, opts StaticOptions) ([]*Resource, error) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return transformContent(name, content, info.ModTime(), opts)
+}
+
+// transformContent returns the resources transformed from content, read from
+// filename, which was last modified at modTime.
+func transformContent(filename string, content []byte, modTime time.Time, opts StaticOptions) ([]*Resource, error) {
 	ext := filepath.Ext(filename)
 	resource := &Resource{
 		ContentType: mime.TypeByExtension(ext),
-		Content: content,
+		Content:     content,
+	}
+	if resource.ContentType == "" {
+		resource.ContentType = sourceTypes[ext]
+	}
+	if resource.ContentType == "" {
+		// Unknown extension; sniff the content like browsers would.
+		resource.ContentType = http.DetectContentType(content)
 	}
 
+	fileType := mediaType(resource.ContentType) // before transformers alter it.
+	resources := []*Resource{resource}
 	if f, has := transformers[ext]; has {
-		if err = f(resource); err != nil {
+		var err error
+		if resources, err = f(resource); err != nil {
+			return nil, err
+		}
+	}
+	seen := map[string]bool{}
+	for len(resources) > 0 {
+		t := mediaType(resources[0].ContentType)
+		f, has := typeTransformers[t]
+		if !has {
+			break
+		}
+		if t == htlContentType && opts.RawHTL {
+			resources[0].ContentType = rawHTLContentType
+			break
+		}
+		if seen[t] {
+			return nil, fmt.Errorf("%s: transformers loop back to %s", filename, t)
+		}
+		seen[t] = true
+		if t == htlContentType {
+			f = htlTransformer(opts)
+		}
+		transformed, err := f(resources[0])
+		if err != nil {
 			return nil, err
 		}
+		resources = append(transformed, resources[1:]...)
 	}
-	return resource, nil
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("%s: transformer produced nothing", filename)
+	}
+	for _, r := range resources {
+		r.ModTime = modTime
+	}
+	if seen[htlContentType] && fileType == htlContentType {
+		resources[0].Source = &Resource{ContentType: htlContentType, Content: content}
+	}
+	if opts.WarnEmpty && seen[htlContentType] && len(content) > 0 && resources[0].empty() {
+		log.Printf("%s: warning: produces an empty document; empty the file if that is intended", filename)
+	}
+	return resources, nil
 }
 
-func HandlerFuncFromFile(filename string, dev bool) (http.HandlerFunc, error) {
-	if dev {
-		return func(w http.ResponseWriter, r *http.Request) {
-			resource, err := ResourceFromFile(filename)
-			if err != nil {
-				fmt.Println(err)
-				// Log?
-				return
-			}
-			w.Header().Add("Content-Type", resource.ContentType)
-			w.Write(resource.Content)
-		}, nil
+// empty reports whether r has no content.
+func (r *Resource) empty() bool {
+	n, _ := r.writeContent(ioutil.Discard)
+	return n == 0
+}
+
+// resourcePath returns the path a resource with the given suffix, transformed
+// from the file at p, is served at.
+func resourcePath(p, suffix string) string {
+	if suffix == "" {
+		return p
 	}
-	resource, err := ResourceFromFile(filename)
+	return strings.TrimSuffix(p, path.Ext(p)) + suffix
+}
+
+// HandlerFuncFromFile returns a handler serving the main resource read from
+// filename.  In dev mode the file is reread on each request.
+func HandlerFuncFromFile(filename string, dev bool) (http.HandlerFunc, error) {
+	handlers, err := handlerFuncsFromFile(filename, StaticOptions{Dev: dev})
 	if err != nil {
 		return nil, err
 	}
+	return handlers[0].h, nil
+}
+
+// suffixHandler serves the resource with the given suffix.
+type suffixHandler struct {
+	suffix string
+	h      http.HandlerFunc
+}
+
+// handlerFuncsFromFile returns a handler for each resource transformed from
+// filename, the main one first.  In dev mode the file is read once to learn
+// which resources it yields, and failing to read it is left for the handlers
+// to report.
+func handlerFuncsFromFile(filename string, opts StaticOptions) ([]suffixHandler, error) {
+	return handlerFuncsFrom(filename, func() ([]*Resource, error) {
+		return resourcesFromFile(filename, opts)
+	}, opts)
+}
+
+// handlerFuncsFrom is handlerFuncsFromFile for the resources load reads from
+// filename.
+func handlerFuncsFrom(filename string, load func() ([]*Resource, error), opts StaticOptions) ([]suffixHandler, error) {
+	resources, err := load()
+	if err != nil && !opts.Dev {
+		return nil, err
+	}
+	if err != nil {
+		// Serve the error where the main resource would be.
+		resources = []*Resource{{Suffix: outExts[filepath.Ext(filename)]}}
+	}
+	handlers := []suffixHandler{}
+	for _, resource := range resources {
+		h := resourceHandlerFunc(resource)
+		if opts.Dev {
+			h = devHandlerFunc(filename, resource.Suffix, load, opts)
+		}
+		handlers = append(handlers, suffixHandler{resource.Suffix, h})
+	}
+	return handlers, nil
+}
+
+// devHandlerFunc rereads filename with load on each request and serves the
+// resource with the given suffix.
+func devHandlerFunc(filename, suffix string, load func() ([]*Resource, error), opts StaticOptions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Content-Type", resource.ContentType)
-		w.Write(resource.Content)
-	}, nil
+		resources, err := load()
+		var resource *Resource
+		for _, res := range resources {
+			if res.Suffix == suffix {
+				resource = res
+			}
+		}
+		if err == nil && resource == nil {
+			err = fmt.Errorf("no longer produces a %q resource", suffix)
+		}
+		if err != nil {
+			log.Printf("%s: %v", filename, err)
+			msg := http.StatusText(http.StatusInternalServerError)
+			if opts.Debug {
+				msg = fmt.Sprintf("%s: %v", filename, err)
+			}
+			http.Error(w, msg, http.StatusInternalServerError)
+			return
+		}
+		serveResource(w, r, resource)
+	}
+}
+
+func resourceHandlerFunc(resource *Resource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveResource(w, r, resource)
+	}
+}
+
+func serveResource(w http.ResponseWriter, r *http.Request, resource *Resource) {
+	if resource.Source != nil {
+		w.Header().Add("Vary", "Accept")
+	}
+	if notModified(w, r, resource.ModTime) {
+		return
+	}
+	if resource.Source != nil {
+		if contentType := sourceType(r.Header.Get("Accept")); contentType != "" {
+			w.Header().Add("Content-Type", contentType)
+			w.Write(resource.Source.Content)
+			return
+		}
+	}
+	w.Header().Add("Content-Type", resource.ContentType)
+	resource.writeContent(w)
 }
 
+// notModified sets Last-Modified to modTime, unless it is zero, and replies
+// 304 Not Modified if r asks only for a resource modified since then.
+func notModified(w http.ResponseWriter, r *http.Request, modTime time.Time) bool {
+	if modTime.IsZero() || modTime.Unix() == 0 {
+		return false
+	}
+	modTime = modTime.Truncate(time.Second) // the precision of http dates.
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modTime.After(since) {
+		return false
+	}
+	h := w.Header()
+	delete(h, "Content-Type")
+	delete(h, "Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// HandlersFromDirs returns handlers for the files under dirs, keyed by their
+// path relative to their directory.  When several directories hold a file at
+// the same path, the one in the latter directory wins.  A DirConfigName file
+// in any directory overrides options for the files under it.
 func HandlersFromDirs(dirs []string, dev bool) (map[string]http.HandlerFunc, error) {
+	handlers, _, err := handlersFromDirs(dirs, StaticOptions{Dev: dev})
+	return handlers, err
+}
+
+// HandlersFromFSLayers returns handlers for the files in layers, such as an
+// embedded theme and a directory of overrides, keyed by their path in their
+// layer.  When several layers hold a file at the same path, the one in the
+// latter layer wins, as with the directories of HandlersFromDirs.  In dev mode
+// the file is reread from its layer on each request.
+func HandlersFromFSLayers(layers []fs.FS, dev bool) (map[string]http.HandlerFunc, error) {
+	opts := StaticOptions{Dev: dev}
 	m := map[string]http.HandlerFunc{}
-	for _, dir := range dirs {
-		err := filepath.Walk(dir, func(path string, info os.FileInfo, errIn error) error {
+	for _, fsys := range layers {
+		fsys := fsys
+		err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, errIn error) error {
 			if errIn != nil {
 				return errIn
 			}
-			subpath := strings.TrimPrefix(path, dir)
-			if subpath == "" {
+			if name == "." {
 				return nil // skip the root.
 			}
-			h, err := HandlerFuncFromFile(path, dev)
+			if opts.ignored(name) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			handlers, err := handlerFuncsFrom(name, func() ([]*Resource, error) {
+				return resourcesFromFS(fsys, name, opts)
+			}, opts)
 			if err != nil {
 				return err
 			}
-			m["/" + strings.TrimLeft(subpath, "/")] = h
+			for _, sh := range handlers {
+				m[resourcePath("/"+name, sh.suffix)] = sh.h
+			}
 			return nil
 		})
 		if err != nil {
@@ -97,3 +502,213 @@ func HandlersFromDirs(dirs []string, dev bool) (map[string]http.HandlerFunc, err
 	}
 	return m, nil
 }
+
+// handlersFromDirs returns the handlers for the files under dirs and the file
+// each path is served from, keyed by path.  dirs are walked in order, each one
+// in lexical order, so a file in a latter directory replaces one at the same
+// path in a former.  With opts.StrictDuplicates such a collision is an error.
+// Dotfiles and files matching opts.Ignore are skipped, and so are files that
+// fail to load with opts.ContinueOnError.  Each file is loaded with opts as
+// overridden by the DirConfigName files of its directory and those above it,
+// up to the one walked.  Files are read and transformed in parallel, but the
+// outcome, or the error, is that of doing so in order.
+func handlersFromDirs(dirs []string, opts StaticOptions) (map[string]http.HandlerFunc, map[string]string, error) {
+	files, err := listDirs(dirs, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	loaded := make([][]suffixHandler, len(files))
+	err = parallel(len(files), func(i int) error {
+		var err error
+		loaded[i], err = handlerFuncsFromFile(files[i].filename, files[i].opts)
+		return opts.skipError(files[i].filename, err)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	m := map[string]http.HandlerFunc{}
+	reg := newRegistry(opts)
+	for i, f := range files {
+		for _, sh := range loaded[i] {
+			key, err := reg.add(resourcePath(f.p, sh.suffix), f.filename)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key] = sh.h
+		}
+	}
+	return m, reg.sources, nil
+}
+
+// skipError returns err, from loading filename, unless opts.ContinueOnError,
+// in which case it logs it and returns nil.
+func (opts StaticOptions) skipError(filename string, err error) error {
+	if err == nil || !opts.ContinueOnError {
+		return err
+	}
+	log.Printf("%s: %v; not serving it", filename, err)
+	return nil
+}
+
+// tooLarge reports whether the file described by info is over
+// opts.MaxInMemoryBytes.
+func (opts StaticOptions) tooLarge(info os.FileInfo) bool {
+	return opts.MaxInMemoryBytes > 0 && info.Size() > opts.MaxInMemoryBytes
+}
+
+// dirFile is a file found by walkDirs: its name, its path under its
+// directory and the options it is loaded with.
+type dirFile struct {
+	filename, p string
+	opts        StaticOptions
+}
+
+// listDirs returns the files under dirs, in the order walkDirs visits them.
+func listDirs(dirs []string, opts StaticOptions) ([]dirFile, error) {
+	files := []dirFile{}
+	err := walkDirs(dirs, opts, func(filename, p string, opts StaticOptions) error {
+		files = append(files, dirFile{filename, p, opts})
+		return nil
+	})
+	return files, err
+}
+
+// loadWorkers is how many files are read and transformed at once.
+var loadWorkers = runtime.GOMAXPROCS(0)
+
+// parallel calls f(i) for each i from 0 to n-1 on up to loadWorkers
+// goroutines, starting them in order.  Once a call fails no further ones are
+// started, and parallel returns the error of the lowest i that failed: the
+// one calling them in order would have returned.
+func parallel(n int, f func(i int) error) error {
+	var (
+		mu     sync.Mutex
+		next   int
+		failed = n // the lowest i that failed, so far.
+		errs   = make([]error, n)
+		wg     sync.WaitGroup
+	)
+	for w := 0; w < loadWorkers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				i := next
+				next++
+				stop := i >= n || i > failed
+				mu.Unlock()
+				if stop {
+					return
+				}
+				if err := f(i); err != nil {
+					mu.Lock()
+					errs[i] = err
+					if i < failed {
+						failed = i
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if failed < n {
+		return errs[failed]
+	}
+	return nil
+}
+
+// WalkFiles calls visit for each file under dir that a Mux with opts would
+// serve, with its name and its slash-separated path under dir, like
+// /css/a.css, in lexical order.  Dotfiles, files matching opts.Ignore and
+// DirConfigName files are skipped, as are directories that are themselves
+// skipped and everything in them.  An error from visit stops the walk.
+func WalkFiles(dir string, opts StaticOptions, visit func(filename, p string) error) error {
+	return walkDirs([]string{dir}, opts, func(filename, p string, _ StaticOptions) error {
+		return visit(filename, p)
+	})
+}
+
+// walkDirs calls visit for each file under dirs, in the order
+// handlersFromDirs describes, with its name, its slash-separated path under
+// its directory, like /css/a.css, and the options for it: opts overridden by
+// the DirConfigName files on the way.  Dotfiles, files matching opts.Ignore,
+// files over MaxInMemoryBytes and DirConfigName files themselves are skipped.
+func walkDirs(dirs []string, opts StaticOptions, visit func(filename, p string, opts StaticOptions) error) error {
+	if err := opts.checkIgnore(); err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		dirOpts := map[string]StaticOptions{} // by cleaned directory name.
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, errIn error) error {
+			if errIn != nil {
+				return errIn
+			}
+			subpath := strings.TrimPrefix(path, dir)
+			if subpath == "" {
+				if !info.IsDir() {
+					return nil
+				}
+				var err error
+				dirOpts[filepath.Clean(path)], err = dirOptions(path, opts)
+				return err // the root is not served itself.
+			}
+			if opts.ignored(filepath.ToSlash(subpath)) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			fileOpts := dirOpts[filepath.Dir(path)]
+			if info.IsDir() {
+				var err error
+				dirOpts[filepath.Clean(path)], err = dirOptions(path, fileOpts)
+				return err // directories have no content of their own.
+			}
+			if info.Name() == DirConfigName {
+				return nil
+			}
+			if fileOpts.tooLarge(info) {
+				log.Printf("warning: %s: %d bytes is over the limit of %d; not serving it",
+					path, info.Size(), fileOpts.MaxInMemoryBytes)
+				return nil
+			}
+			return visit(path, "/"+strings.TrimLeft(filepath.ToSlash(subpath), "/"), fileOpts)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registry keeps track of the file each path is served from, as paths are
+// registered in the order the files are walked.
+type registry struct {
+	opts    StaticOptions
+	sources map[string]string // File each key is served from.
+	cased   map[string]string // Path each key was registered from.
+}
+
+func newRegistry(opts StaticOptions) *registry {
+	return &registry{opts: opts, sources: map[string]string{}, cased: map[string]string{}}
+}
+
+// add registers path p as served from filename and returns its key.  It
+// fails if p is taken and opts.StrictDuplicates, and logs when p differs only
+// by case from the path it replaces.
+func (reg *registry) add(p, filename string) (string, error) {
+	key := reg.opts.pathKey(p)
+	if prev, has := reg.sources[key]; has {
+		if reg.opts.StrictDuplicates {
+			return "", fmt.Errorf("%s is served by both %s and %s", p, prev, filename)
+		}
+		if reg.cased[key] != p {
+			log.Printf("warning: %s and %s differ only by case; serving %s", prev, filename, filename)
+		}
+	}
+	reg.sources[key] = filename
+	reg.cased[key] = p
+	return key, nil
+}
@@ -0,0 +1,1734 @@
+package static
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// writeFiles creates files (keyed by slash-separated path) under a new
+// temporary directory and returns the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// get serves a GET request for p and returns the recorded response.
+func get(h http.Handler, p string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", p, nil))
+	return w
+}
+
+func TestMuxListing(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.txt":          "a",
+		"sub/b.txt":      "b",
+		"docs/index.htl": "(p hi)",
+	})
+	for _, listing := range []bool{false, true} {
+		m, err := NewMux([]string{dir}, StaticOptions{Index: "/index.html", Listing: listing})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := get(m, "/sub/")
+		if !listing {
+			if w.Code != http.StatusNotFound {
+				t.Errorf("listing off: GET /sub/ code = %d, want 404", w.Code)
+			}
+		} else {
+			body := w.Body.String()
+			if w.Code != http.StatusOK || !strings.Contains(body, `<a href="/sub/b.txt">b.txt</a>`) {
+				t.Errorf("listing on: GET /sub/ = %d %q", w.Code, body)
+			}
+			body = get(m, "/").Body.String()
+			for _, want := range []string{`<a href="/a.txt">a.txt</a>`, `<a href="/sub/">sub/</a>`} {
+				if !strings.Contains(body, want) {
+					t.Errorf("listing on: GET / = %q, missing %q", body, want)
+				}
+			}
+		}
+
+		// An index file wins over the listing.
+		if got := get(m, "/docs/").Body.String(); got != "<p>hi</p>" {
+			t.Errorf("GET /docs/ = %q, want the index", got)
+		}
+		if w := get(m, "/missing/"); w.Code != http.StatusNotFound {
+			t.Errorf("GET /missing/ code = %d, want 404", w.Code)
+		}
+	}
+}
+
+func TestMuxSecurityHeaders(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.txt": "a"})
+	cases := []struct {
+		opts StaticOptions
+		want map[string]string
+	}{
+		{StaticOptions{},
+			map[string]string{
+				"X-Content-Type-Options":  "nosniff",
+				"X-Frame-Options":         "",
+				"Content-Security-Policy": "",
+			}},
+		{StaticOptions{
+			AllowSniffing:         true,
+			FrameOptions:          "DENY",
+			ContentSecurityPolicy: "default-src 'self'",
+		},
+			map[string]string{
+				"X-Content-Type-Options":  "",
+				"X-Frame-Options":         "DENY",
+				"Content-Security-Policy": "default-src 'self'",
+			}},
+	}
+	for _, c := range cases {
+		m, err := NewMux([]string{dir}, c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{"/a.txt", "/missing"} {
+			w := get(m, p)
+			for k, want := range c.want {
+				if k == "X-Content-Type-Options" && w.Code == http.StatusNotFound {
+					continue // http.Error always sends nosniff.
+				}
+				if got := w.Header().Get(k); got != want {
+					t.Errorf("%+v: GET %s header %s = %q, want %q", c.opts, p, k, got, want)
+				}
+			}
+			if _, has := w.Header()["Content-Security-Policy"]; has && c.opts.ContentSecurityPolicy == "" {
+				t.Errorf("%+v: GET %s sent an empty Content-Security-Policy", c.opts, p)
+			}
+		}
+	}
+}
+
+func TestDevTransformError(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"bad.htl": "(p\n (b)"})
+	for _, debug := range []bool{false, true} {
+		m, err := NewMux([]string{dir}, StaticOptions{Dev: true, Debug: debug})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := get(m, "/bad.html")
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("debug=%v: code = %d, want 500", debug, w.Code)
+		}
+		if got := strings.Contains(w.Body.String(), "line 2 column 4"); got != debug {
+			t.Errorf("debug=%v: body %q mentions the error position: %v", debug, w.Body.String(), got)
+		}
+	}
+	if _, err := NewMux([]string{dir}, StaticOptions{}); err == nil {
+		t.Errorf("NewMux in non-dev mode succeeded, want the parse error")
+	}
+}
+
+func TestMuxRedirectToCanonical(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.txt":          "a",
+		"docs/index.htl": "(p hi)",
+	})
+	cases := []struct {
+		path, location string
+		code           int
+	}{
+		{"/a.txt", "", http.StatusOK},
+		{"/docs/", "", http.StatusOK},
+		{"/", "", http.StatusOK},
+		{"/docs", "/docs/", http.StatusMovedPermanently},
+		{"/docs?x=1", "/docs/?x=1", http.StatusMovedPermanently},
+		{"/a.txt/", "/a.txt", http.StatusMovedPermanently},
+		{"//docs//index.html", "/docs/index.html", http.StatusMovedPermanently},
+		{"/missing/", "", http.StatusNotFound},
+	}
+	for _, redirect := range []bool{false, true} {
+		m, err := NewMux([]string{dir}, StaticOptions{
+			Index:               "/index.html",
+			Listing:             true,
+			RedirectToCanonical: redirect,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range cases {
+			code, location := c.code, c.location
+			if !redirect && code == http.StatusMovedPermanently {
+				code, location = http.StatusOK, ""
+			}
+			w := get(m, c.path)
+			if w.Code != code || w.Header().Get("Location") != location {
+				t.Errorf("redirect=%v: GET %s = %d to %q, want %d to %q",
+					redirect, c.path, w.Code, w.Header().Get("Location"), code, location)
+			}
+		}
+	}
+}
+
+func TestMuxHandle(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.txt": "a"})
+	m, err := NewMux([]string{dir}, StaticOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Handle("/healthz", &Resource{
+		ContentType: "application/json",
+		Content:     []byte(`{"ok":true}`),
+	})
+	w := get(m, "/healthz")
+	if got, want := w.Header().Get("Content-Type"), "application/json"; got != want {
+		t.Errorf("Content-Type = %q, want %q", got, want)
+	}
+	if got, want := w.Body.String(), `{"ok":true}`; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("synthetic resources miss the security headers")
+	}
+	if got := get(m, "/a.txt").Body.String(); got != "a" {
+		t.Errorf("GET /a.txt = %q after Handle", got)
+	}
+}
+
+func TestMuxMetrics(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.txt": "hello"})
+	var got []RequestMetrics
+	m, err := NewMux([]string{dir}, StaticOptions{
+		Metrics: func(rm RequestMetrics) { got = append(got, rm) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(m, "/a.txt")
+	get(m, "/missing")
+	want := []RequestMetrics{
+		{Path: "/a.txt", Status: http.StatusOK, Bytes: 5},
+		{Path: "/missing", Status: http.StatusNotFound, Bytes: int64(len("404 page not found\n"))},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d reports, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Duration < 0 {
+			t.Errorf("report %d has duration %v", i, got[i].Duration)
+		}
+		got[i].Duration = 0
+		if got[i] != want[i] {
+			t.Errorf("report %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestResourceFromFileContentType(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"README":     "plain words",
+		"logo":       "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"notes.json": "plain words",
+		"page.htl":   "(p hi)",
+	})
+	for name, want := range map[string]string{
+		"README":     "text/plain; charset=utf-8",
+		"logo":       "image/png",
+		"notes.json": "application/json",
+		"page.htl":   "text/html; charset=utf-8",
+	} {
+		r, err := ResourceFromFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.ContentType != want {
+			t.Errorf("%s: ContentType = %q, want %q", name, r.ContentType, want)
+		}
+	}
+}
+
+func TestIntegrityHash(t *testing.T) {
+	r := &Resource{Content: []byte("alert('Hello, world.');")}
+	for algo, want := range map[string]string{
+		"sha256": "sha256-qznLcsROx4GACP2dm0UCKCzCG+HiZ1guq6ZZDob/Tng=",
+		"sha384": "sha384-H8BRh8j48O9oYatfu5AZzq6A9RINhZO5H16dQZngK7T62em8MUt1FLm52t+eX6xO",
+		"sha512": "sha512-Q2bFTOhEALkN8hOms2FKTDLy7eugP2zFZ1T8LCvX42Fp3WoNr3bjZSAHeOsHrbV1Fu9/A0EzCinRE7Af1ofPrw==",
+		"md5":    "",
+	} {
+		if got := r.IntegrityHash(algo); got != want {
+			t.Errorf("IntegrityHash(%q) = %q, want %q", algo, got, want)
+		}
+	}
+}
+
+func TestMultiOutputTransformer(t *testing.T) {
+	// A transformer splitting "html|css" into a page and its stylesheet.
+	transformers[".split"] = func(r *Resource) ([]*Resource, error) {
+		parts := strings.SplitN(string(r.Content), "|", 2)
+		return []*Resource{
+			{ContentType: "text/html", Content: []byte(parts[0])},
+			{ContentType: "text/css", Content: []byte(parts[1]), Suffix: ".css"},
+		}, nil
+	}
+	defer delete(transformers, ".split")
+
+	dir := writeFiles(t, map[string]string{"page.split": "<p>hi</p>|p {}"})
+	for _, dev := range []bool{false, true} {
+		m, err := NewMux([]string{dir}, StaticOptions{Dev: dev})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := m.Paths(), []string{"/page.css", "/page.split"}; strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("dev=%v: Paths() = %q, want %q", dev, got, want)
+		}
+		for p, want := range map[string]string{
+			"/page.split": "text/html <p>hi</p>",
+			"/page.css":   "text/css p {}",
+		} {
+			w := get(m, p)
+			if got := w.Header().Get("Content-Type") + " " + w.Body.String(); got != want {
+				t.Errorf("dev=%v: GET %s = %q, want %q", dev, p, got, want)
+			}
+		}
+	}
+}
+
+func TestRegisterTransformer(t *testing.T) {
+	// A stand-in for a scss compiler, dropping the $ of variables.
+	RegisterTransformer(".scss", func(r *Resource) ([]*Resource, error) {
+		r.ContentType = "text/css; charset=utf-8"
+		r.Content = bytes.Replace(r.Content, []byte("$"), nil, -1)
+		return []*Resource{r}, nil
+	}, ".css")
+	defer func() {
+		delete(transformers, ".scss")
+		delete(outExts, ".scss")
+	}()
+
+	dir := writeFiles(t, map[string]string{"css/site.scss": "p { color: $red }", "a.css": "a {}"})
+	for _, dev := range []bool{false, true} {
+		m, err := NewMux([]string{dir}, StaticOptions{Dev: dev})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := m.Paths(), []string{"/a.css", "/css/site.css"}; strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("dev=%v: Paths() = %q, want %q", dev, got, want)
+		}
+		w := get(m, "/css/site.css")
+		if got, want := w.Header().Get("Content-Type")+" "+w.Body.String(), "text/css; charset=utf-8 p { color: red }"; got != want {
+			t.Errorf("dev=%v: GET /css/site.css = %q, want %q", dev, got, want)
+		}
+		if got := m.Source("/css/site.css"); got != filepath.Join(dir, "css", "site.scss") {
+			t.Errorf("dev=%v: Source(/css/site.css) = %q, want the scss file", dev, got)
+		}
+	}
+
+	// A suffix the transformer sets wins.
+	RegisterTransformer(".scss", func(r *Resource) ([]*Resource, error) {
+		r.Suffix = ".min.css"
+		return []*Resource{r}, nil
+	}, ".css")
+	resources, err := ResourcesFromFile(filepath.Join(dir, "css", "site.scss"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resources[0].Suffix; got != ".min.css" {
+		t.Errorf("Suffix = %q, want the transformer's .min.css", got)
+	}
+}
+
+func TestHTLServedAsHTML(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"index.htl":     "(p home)",
+		"docs/page.htl": "(p page)",
+		"docs/old.html": "<p>old</p>",
+		"raw.htl.txt":   "x",
+	})
+	for _, dev := range []bool{false, true} {
+		m, err := NewMux([]string{dir}, StaticOptions{Dev: dev, Index: "/index.html"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"/docs/old.html", "/docs/page.html", "/index.html", "/raw.htl.txt"}
+		if got := m.Paths(); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("dev=%v: Paths() = %q, want %q", dev, got, want)
+		}
+		for p, want := range map[string]string{"/": "<p>home</p>", "/docs/page.html": "<p>page</p>"} {
+			if got := get(m, p).Body.String(); got != want {
+				t.Errorf("dev=%v: GET %s = %q, want %q", dev, p, got, want)
+			}
+		}
+
+		// The .htl paths htl files were served at before redirect to .html.
+		for p, want := range map[string]string{
+			"/docs/page.htl":      "/docs/page.html",
+			"/docs//page.htl?x=1": "/docs/page.html?x=1",
+			"/index.htl":          "/index.html",
+		} {
+			w := get(m, p)
+			if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != want {
+				t.Errorf("dev=%v: GET %s = %d to %q, want 301 to %q", dev, p, w.Code, w.Header().Get("Location"), want)
+			}
+		}
+		// Only those of htl files.
+		for _, p := range []string{"/docs/old.htl", "/missing.htl"} {
+			if w := get(m, p); w.Code != http.StatusNotFound {
+				t.Errorf("dev=%v: GET %s = %d, want 404", dev, p, w.Code)
+			}
+		}
+	}
+}
+
+func TestMuxPrecompressed(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"app.js":       "plain",
+		"app.js.gz":    "gzipped",
+		"app.js.br":    "brotli",
+		"style.css":    "plain",
+		"style.css.gz": "gzipped",
+		"page.html":    "plain",
+	})
+	m, err := NewMux([]string{dir}, StaticOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path, accept      string
+		body, encoding    string
+		contentTypePrefix string
+	}{
+		{"/app.js", "", "plain", "", "text/javascript"},
+		{"/app.js", "gzip, deflate", "gzipped", "gzip", "text/javascript"},
+		{"/app.js", "gzip, br", "brotli", "br", "text/javascript"},
+		{"/app.js", "gzip, br;q=0", "gzipped", "gzip", "text/javascript"},
+		{"/style.css", "br", "plain", "", "text/css"},
+		{"/style.css", "br, GZIP;q=0.5", "gzipped", "gzip", "text/css"},
+		{"/page.html", "gzip, br", "plain", "", "text/html"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", c.path, nil)
+		req.Header.Set("Accept-Encoding", c.accept)
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, req)
+		if w.Body.String() != c.body || w.Header().Get("Content-Encoding") != c.encoding ||
+			!strings.HasPrefix(w.Header().Get("Content-Type"), c.contentTypePrefix) {
+			t.Errorf("GET %s accepting %q = %q encoded %q as %q; want %q encoded %q as %s",
+				c.path, c.accept, w.Body.String(), w.Header().Get("Content-Encoding"),
+				w.Header().Get("Content-Type"), c.body, c.encoding, c.contentTypePrefix)
+		}
+	}
+}
+
+func TestMuxGzip(t *testing.T) {
+	large := strings.Repeat("p { color: red }\n", 500)
+	dir := writeFiles(t, map[string]string{
+		"small.css":  "p {}",
+		"large.css":  large,
+		"large.png":  large,
+		"pre.css":    large,
+		"pre.css.gz": "gzipped",
+		"page.htl":   "(p" + strings.Repeat(" \"hello\"", 300) + ")",
+		"edge.txt":   strings.Repeat("x", 100),
+	})
+	request := func(m *Mux, p, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", p, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, req)
+		return w
+	}
+	gunzip := func(w *httptest.ResponseRecorder) (string, *gzip.Reader) {
+		zr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b), zr
+	}
+
+	m, err := NewMux([]string{dir}, StaticOptions{Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path, accept, encoding string
+	}{
+		{"/small.css", "gzip", ""},
+		{"/large.css", "gzip", "gzip"},
+		{"/large.css", "br", ""},
+		{"/large.png", "gzip", ""},
+		{"/pre.css", "gzip", "gzip"},
+		{"/page.html", "gzip", "gzip"},
+	}
+	for _, c := range cases {
+		w := request(m, c.path, c.accept)
+		if got := w.Header().Get("Content-Encoding"); got != c.encoding {
+			t.Errorf("GET %s accepting %q: Content-Encoding %q, want %q", c.path, c.accept, got, c.encoding)
+		}
+		if got := w.Header().Values("Vary"); strings.Count(strings.Join(got, ","), "Accept-Encoding") != 1 {
+			t.Errorf("GET %s: Vary %q, want Accept-Encoding once", c.path, got)
+		}
+	}
+	if w := request(m, "/large.css", "gzip"); w.Code == http.StatusOK {
+		if got, _ := gunzip(w); got != large {
+			t.Errorf("GET /large.css gunzipped differs from the file")
+		}
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/css") {
+			t.Errorf("GET /large.css gzipped has Content-Type %q", got)
+		}
+	}
+	if got := request(m, "/pre.css", "gzip").Body.String(); got != "gzipped" {
+		t.Errorf("GET /pre.css = %q, want the precompressed sibling as is", got)
+	}
+	if got := request(m, "/small.css", "gzip").Body.String(); got != "p {}" {
+		t.Errorf("GET /small.css = %q, want it uncompressed", got)
+	}
+
+	m, err = NewMux([]string{dir}, StaticOptions{Gzip: true, GzipMinSize: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := request(m, "/edge.txt", "gzip").Header().Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("GET /edge.txt with GzipMinSize 50: Content-Encoding %q, want gzip", got)
+	}
+
+	// The XFL byte of the gzip header tells the fastest and best levels apart.
+	for level, xfl := range map[int]byte{gzip.BestSpeed: 4, gzip.BestCompression: 2} {
+		m, err := NewMux([]string{dir}, StaticOptions{Gzip: true, GzipLevel: level})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := request(m, "/large.css", "gzip")
+		if b := w.Body.Bytes(); len(b) < 10 || b[8] != xfl {
+			t.Errorf("GzipLevel %d: gzip header % x, want XFL %d", level, b[:10], xfl)
+		}
+		if got, _ := gunzip(w); got != large {
+			t.Errorf("GzipLevel %d: gunzipped body differs from the file", level)
+		}
+	}
+
+	if _, err := NewMux([]string{dir}, StaticOptions{Gzip: true, GzipLevel: 42}); err == nil {
+		t.Errorf("NewMux accepted GzipLevel 42")
+	}
+}
+
+func TestMuxDuplicates(t *testing.T) {
+	first := writeFiles(t, map[string]string{"a.txt": "first", "sub/b.txt": "first b", "only.txt": "only"})
+	second := writeFiles(t, map[string]string{"a.txt": "second", "sub/b.txt": "second b"})
+	m, err := NewMux([]string{first, second}, StaticOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		p, body, source string
+	}{
+		{"/a.txt", "second", filepath.Join(second, "a.txt")},
+		{"/sub/b.txt", "second b", filepath.Join(second, "sub", "b.txt")},
+		{"/only.txt", "only", filepath.Join(first, "only.txt")},
+	}
+	for _, c := range cases {
+		if got := get(m, c.p).Body.String(); got != c.body {
+			t.Errorf("GET %s = %q, want %q", c.p, got, c.body)
+		}
+		if got := m.Source(c.p); got != c.source {
+			t.Errorf("Source(%q) = %q, want %q", c.p, got, c.source)
+		}
+	}
+
+	_, err = NewMux([]string{first, second}, StaticOptions{StrictDuplicates: true})
+	if err == nil || !strings.Contains(err.Error(), "/a.txt is served by both") {
+		t.Errorf("NewMux with StrictDuplicates: err = %v, want a collision on /a.txt", err)
+	}
+	if _, err := NewMux([]string{first}, StaticOptions{StrictDuplicates: true}); err != nil {
+		t.Errorf("NewMux with StrictDuplicates and no collision: %v", err)
+	}
+}
+
+func TestMuxContinueOnError(t *testing.T) {
+	first := writeFiles(t, map[string]string{"bad.htl": "(p ok)"})
+	second := writeFiles(t, map[string]string{
+		"a.htl":     "(p a)",
+		"bad.htl":   "(p",
+		"b.css":     "b {}",
+		"sub/c.txt": "c",
+	})
+	if _, err := NewMux([]string{first, second}, StaticOptions{}); err == nil {
+		t.Errorf("NewMux with a malformed file succeeded, want an error")
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	opts := StaticOptions{ContinueOnError: true}
+	m, err := NewMux([]string{first, second}, opts)
+	if err != nil {
+		t.Fatalf("NewMux with ContinueOnError: %v", err)
+	}
+	for p, want := range map[string]string{
+		"/a.html": "<p>a</p>", "/b.css": "b {}", "/sub/c.txt": "c",
+		// The broken file does not replace the one before it.
+		"/bad.html": "<p>ok</p>",
+	} {
+		if got := get(m, p).Body.String(); got != want {
+			t.Errorf("GET %s = %q, want %q", p, got, want)
+		}
+	}
+	if !strings.Contains(logged.String(), filepath.Join(second, "bad.htl")) {
+		t.Errorf("log = %q, want the broken file named", logged.String())
+	}
+}
+
+func TestFileMux(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"report.htl": "(p \"hi\")"})
+	for _, dev := range []bool{false, true} {
+		m, err := NewFileMux(filepath.Join(dir, "report.htl"), StaticOptions{Dev: dev})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{"/", "/report.html"} {
+			w := get(m, p)
+			if got, want := w.Body.String(), "<p>hi</p>"; w.Code != http.StatusOK || got != want {
+				t.Errorf("dev %v: GET %s = %d %q, want 200 %q", dev, p, w.Code, got, want)
+			}
+			if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+				t.Errorf("dev %v: GET %s Content-Type = %q, want text/html", dev, p, got)
+			}
+		}
+		if w := get(m, "/other.html"); w.Code != http.StatusNotFound {
+			t.Errorf("dev %v: GET /other.htl = %d, want 404", dev, w.Code)
+		}
+	}
+	if _, err := NewFileMux(filepath.Join(dir, "missing.htl"), StaticOptions{}); err == nil {
+		t.Errorf("NewFileMux of a missing file succeeded")
+	}
+}
+
+func TestStreamHTML(t *testing.T) {
+	page := "(html (body" + strings.Repeat(" (p :class x \"<row>\" (a :href /y \"link\"))", 5000) + "))"
+	dir := writeFiles(t, map[string]string{"page.htl": page, "a.css": "p {}"})
+	buffered, err := NewMux([]string{dir}, StaticOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed, err := NewMux([]string{dir}, StaticOptions{StreamHTML: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/page.html", "/a.css"} {
+		want, got := get(buffered, p), get(streamed, p)
+		if got.Body.String() != want.Body.String() {
+			t.Errorf("GET %s streamed differs from buffered", p)
+		}
+		if g, w := got.Header().Get("Content-Type"), want.Header().Get("Content-Type"); g != w {
+			t.Errorf("GET %s streamed Content-Type = %q, want %q", p, g, w)
+		}
+	}
+
+	resources, err := resourcesFromFile(filepath.Join(dir, "page.htl"), StaticOptions{StreamHTML: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := resources[0]; r.Tree == nil || len(r.Content) != 0 {
+		t.Errorf("streamed resource keeps %d bytes of html, want none", len(r.Content))
+	}
+	buffer, err := ResourceFromFile(filepath.Join(dir, "page.htl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resources[0].IntegrityHash("sha256"), buffer.IntegrityHash("sha256"); got != want {
+		t.Errorf("streamed IntegrityHash = %q, want %q", got, want)
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"page.htl": "(p hi)", "a.css": "p {}"})
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"page.htl", "a.css"} {
+		if err := os.Chtimes(filepath.Join(dir, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resource, err := ResourceFromFile(filepath.Join(dir, "page.htl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resource.ModTime.Equal(modTime) {
+		t.Errorf("ModTime = %v, want %v", resource.ModTime, modTime)
+	}
+
+	request := func(m *Mux, p, since string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", p, nil)
+		if since != "" {
+			r.Header.Set("If-Modified-Since", since)
+		}
+		m.ServeHTTP(w, r)
+		return w
+	}
+	for _, dev := range []bool{false, true} {
+		m, err := NewMux([]string{dir}, StaticOptions{Dev: dev})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{"/page.html", "/a.css"} {
+			w := request(m, p, "")
+			if got, want := w.Header().Get("Last-Modified"), "Fri, 01 Mar 2024 12:00:00 GMT"; got != want {
+				t.Errorf("dev %v: GET %s Last-Modified = %q, want %q", dev, p, got, want)
+			}
+			if w := request(m, p, "Fri, 01 Mar 2024 12:00:00 GMT"); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+				t.Errorf("dev %v: GET %s since its mtime = %d %q, want 304 and no body", dev, p, w.Code, w.Body.String())
+			}
+			if w := request(m, p, "Fri, 01 Mar 2024 11:59:59 GMT"); w.Code != http.StatusOK || w.Body.Len() == 0 {
+				t.Errorf("dev %v: GET %s since before its mtime = %d, want 200", dev, p, w.Code)
+			}
+		}
+	}
+
+	m, err := NewMux([]string{dir}, StaticOptions{Dev: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := modTime.Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "page.htl"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if w := request(m, "/page.html", "Fri, 01 Mar 2024 12:00:00 GMT"); w.Code != http.StatusOK ||
+		w.Header().Get("Last-Modified") != "Fri, 01 Mar 2024 13:00:00 GMT" {
+		t.Errorf("dev mode after touching the file: %d, Last-Modified %q; want 200 and the new time",
+			w.Code, w.Header().Get("Last-Modified"))
+	}
+
+	m.Handle("/healthz", &Resource{ContentType: "text/plain", Content: []byte("ok")})
+	if got := request(m, "/healthz", "").Header().Get("Last-Modified"); got != "" {
+		t.Errorf("resource without ModTime sent Last-Modified %q", got)
+	}
+}
+
+func TestMuxFallbacks(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"index.htl":          "(p home)",
+		"app/shell.html":     "<div id=app></div>",
+		"app/main.js":        "start()",
+		"app/admin/index.js": "admin()",
+	})
+	m, err := NewMux([]string{dir}, StaticOptions{
+		Index:               "/index.html",
+		RedirectToCanonical: true,
+		Fallbacks:           map[string]string{"/app/": "/app/shell.html", "/app/admin/": "/app/admin/index.js"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path, body, contentType string
+	}{
+		{"/app/users/42/edit", "<div id=app></div>", "text/html; charset=utf-8"},
+		{"/app/users/42/", "<div id=app></div>", "text/html; charset=utf-8"},
+		{"/app", "<div id=app></div>", "text/html; charset=utf-8"},
+		{"/app/main.js", "start()", "text/javascript; charset=utf-8"},
+		{"/app/admin/users", "admin()", "text/javascript; charset=utf-8"},
+		{"/", "<p>home</p>", "text/html; charset=utf-8"},
+	}
+	for _, c := range cases {
+		w := get(m, c.path)
+		if w.Code != http.StatusOK || w.Body.String() != c.body {
+			t.Errorf("GET %s = %d %q, want 200 %q", c.path, w.Code, w.Body.String(), c.body)
+		}
+		if got := w.Header().Get("Content-Type"); got != c.contentType {
+			t.Errorf("GET %s Content-Type = %q, want %q", c.path, got, c.contentType)
+		}
+	}
+	if w := get(m, "/other/x"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /other/x = %d, want 404", w.Code)
+	}
+
+	_, err = NewMux([]string{dir}, StaticOptions{Fallbacks: map[string]string{"/app/": "/app/missing.html"}})
+	if err == nil {
+		t.Errorf("NewMux accepted a fallback to a path nothing serves")
+	}
+}
+
+func TestMuxCaseInsensitive(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"About.html":     "<p>about</p>",
+		"Docs/Guide.htl": "(p guide)",
+	})
+	sensitive, err := NewMux([]string{dir}, StaticOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := get(sensitive, "/about.html"); w.Code != http.StatusNotFound {
+		t.Errorf("case-sensitive GET /about.html = %d, want 404", w.Code)
+	}
+
+	m, err := NewMux([]string{dir}, StaticOptions{CaseInsensitive: true, RedirectToCanonical: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range map[string]string{
+		"/about.html":      "<p>about</p>",
+		"/About.html":      "<p>about</p>",
+		"/ABOUT.HTML":      "<p>about</p>",
+		"/docs/guide.html": "<p>guide</p>",
+	} {
+		if w := get(m, p); w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("GET %s = %d %q, want 200 %q", p, w.Code, w.Body.String(), want)
+		}
+	}
+	if got, want := m.Source("/About.html"), filepath.Join(dir, "About.html"); got != want {
+		t.Errorf("Source(/About.html) = %q, want %q", got, want)
+	}
+}
+
+func TestMuxCaseCollisions(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	first := writeFiles(t, map[string]string{"About.html": "first", "a.css": "p {}"})
+	second := writeFiles(t, map[string]string{"about.html": "second", "a.css": "p { color: red }"})
+	m, err := NewMux([]string{first, second}, StaticOptions{CaseInsensitive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := get(m, "/ABOUT.html").Body.String(); got != "second" {
+		t.Errorf("GET /ABOUT.html = %q, want the latter file", got)
+	}
+	got := logs.String()
+	if !strings.Contains(got, "About.html") || !strings.Contains(got, "differ only by case") {
+		t.Errorf("logs %q do not warn about the collision", got)
+	}
+	if strings.Contains(got, "a.css") {
+		t.Errorf("logs %q warn about a same-case duplicate", got)
+	}
+
+	_, err = NewMux([]string{first, second}, StaticOptions{CaseInsensitive: true, StrictDuplicates: true})
+	if err == nil {
+		t.Errorf("NewMux with StrictDuplicates accepted paths differing only by case")
+	}
+}
+
+func TestDevBanner(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"page.htl":  "(html (body.x (p hi)))",
+		"frag.htl":  "(p hi)",
+		"empty.htl": "; nothing yet\n",
+		"a.css":     "p {}",
+	})
+	banner := `<div id="ffe-dev-banner" style="` + devBannerStyle + `">dev build</div>`
+	cases := []struct {
+		dev, banner bool
+		want        map[string]string
+	}{
+		{true, true, map[string]string{
+			"/page.html": `<html><body class="x"><p>hi</p>` + banner + `</body></html>`,
+			"/frag.html": `<p>hi</p>` + banner,
+			"/a.css":     "p {}",
+		}},
+		{false, true, map[string]string{
+			"/page.html": `<html><body class="x"><p>hi</p></body></html>`,
+			"/frag.html": `<p>hi</p>`,
+		}},
+		{true, false, map[string]string{
+			"/page.html": `<html><body class="x"><p>hi</p></body></html>`,
+		}},
+	}
+	for _, c := range cases {
+		m, err := NewMux([]string{dir}, StaticOptions{Dev: c.dev, DevBanner: c.banner})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for p, want := range c.want {
+			if got := get(m, p).Body.String(); got != want {
+				t.Errorf("dev %v, banner %v: GET %s = %q, want %q", c.dev, c.banner, p, got, want)
+			}
+		}
+		if got := get(m, "/empty.html").Body.String(); strings.Contains(got, "ffe-dev-banner") {
+			t.Errorf("dev %v, banner %v: banner on an empty page: %q", c.dev, c.banner, got)
+		}
+	}
+}
+
+func TestSourceHeader(t *testing.T) {
+	common := writeFiles(t, map[string]string{"index.htl": "(p common)", "a.css": "p {}"})
+	site := writeFiles(t, map[string]string{"index.htl": "(p site)"})
+	cases := []struct {
+		dev, header bool
+		want        map[string]string
+	}{
+		{true, true, map[string]string{
+			"/":           filepath.Join(site, "index.htl"),
+			"/index.html": filepath.Join(site, "index.htl"),
+			"/a.css":      filepath.Join(common, "a.css"),
+			"/missing.js": "",
+		}},
+		{false, true, map[string]string{"/": "", "/a.css": ""}},
+		{true, false, map[string]string{"/": "", "/a.css": ""}},
+	}
+	for _, c := range cases {
+		m, err := NewMux([]string{common, site}, StaticOptions{Dev: c.dev, SourceHeader: c.header, Index: "/index.html"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for p, want := range c.want {
+			if got := get(m, p).Header().Get("X-Ffe-Source"); got != want {
+				t.Errorf("dev %v, header %v: GET %s: X-Ffe-Source = %q, want %q", c.dev, c.header, p, got, want)
+			}
+		}
+	}
+}
+
+// TestDirConfig checks that settings apply only to the subtree of the
+// directory giving them: indentation in sub, which sub/compact turns off
+// again, and undefined env placeholders in sub/deeper.
+func TestDirConfig(t *testing.T) {
+	os.Unsetenv("VULCAN_TEST_UNSET")
+	dir := writeFiles(t, map[string]string{
+		"index.htl":              "(div (p top))",
+		"sub/.ffe.json":          `{"indent_html": "  "}`,
+		"sub/page.htl":           "(div (p sub))",
+		"sub/deeper/.ffe.json":   `{"allow_undefined_env": true}`,
+		"sub/deeper/page.htl":    "(div (p \"[{{env:VULCAN_TEST_UNSET}}]\"))",
+		"sub/compact/.ffe.json":  `{"indent_html": ""}`,
+		"sub/compact/page.htl":   "(div (p compact))",
+		"other/page.htl":         "(div (p other))",
+		"other/missing/page.htl": "(p \"[{{env:VULCAN_TEST_UNSET}}]\")",
+	})
+	want := map[string]string{
+		"/index.html":            "<div><p>top</p></div>",
+		"/sub/page.html":         "<div>\n  <p>sub</p>\n</div>",
+		"/sub/deeper/page.html":  "<div>\n  <p>[]</p>\n</div>",
+		"/sub/compact/page.html": "<div><p>compact</p></div>",
+		"/other/page.html":       "<div><p>other</p></div>",
+	}
+	for _, stream := range []bool{false, true} {
+		m, err := NewMux([]string{dir}, StaticOptions{StreamHTML: stream, ContinueOnError: true, ServeDotfiles: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for p, body := range want {
+			if got := get(m, p).Body.String(); got != body {
+				t.Errorf("stream %v: GET %s = %q, want %q", stream, p, got, body)
+			}
+		}
+		for _, p := range []string{"/other/missing/page.html", "/sub/.ffe.json", "/sub/deeper/.ffe.json"} {
+			if code := get(m, p).Code; code != http.StatusNotFound {
+				t.Errorf("stream %v: GET %s = %d, want 404", stream, p, code)
+			}
+		}
+	}
+
+	bad := writeFiles(t, map[string]string{"sub/.ffe.json": `{"indent": "  "}`, "sub/a.htl": "(p)"})
+	if _, err := NewMux([]string{bad}, StaticOptions{}); err == nil || !strings.Contains(err.Error(), DirConfigName) {
+		t.Errorf("NewMux with an unknown setting in %s: error = %v, want one naming the file", DirConfigName, err)
+	}
+}
+
+func TestHTLComponents(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"page.htl": "(defcomponent box (div.box (slot)))\n(box (p hi))",
+		"bad.htl":  "(defcomponent box (div.box))\n(box (p hi))",
+	})
+	m, err := NewMux([]string{dir}, StaticOptions{Dev: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := get(m, "/page.html").Body.String(), "<div class=\"box\"><p>hi</p></div>"; got != want {
+		t.Errorf("GET /page.htl = %q, want %q", got, want)
+	}
+	if got := get(m, "/bad.html").Code; got != http.StatusInternalServerError {
+		t.Errorf("GET /bad.htl: status %d, want 500 for a child with no slot", got)
+	}
+}
+
+func TestHTLEnv(t *testing.T) {
+	t.Setenv("VULCAN_TEST_SHA", "abc<123>")
+	dir := writeFiles(t, map[string]string{
+		"page.htl":    "(p :data-sha \"{{env:VULCAN_TEST_SHA}}\" (b \"{{user}}\"))",
+		"missing.htl": "(p \"[{{env:VULCAN_TEST_UNSET}}]\")",
+	})
+	r, err := ResourceFromFile(filepath.Join(dir, "page.htl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(r.Content), "<p data-sha=\"abc&lt;123&gt;\"><b>{{user}}</b></p>"; got != want {
+		t.Errorf("page.htl = %q, want %q", got, want)
+	}
+
+	_, err = ResourceFromFile(filepath.Join(dir, "missing.htl"))
+	if err == nil || !strings.Contains(err.Error(), "VULCAN_TEST_UNSET") {
+		t.Errorf("ResourceFromFile with an unset variable = %v, want an error naming it", err)
+	}
+	resources, err := resourcesFromFile(filepath.Join(dir, "missing.htl"), StaticOptions{AllowUndefinedEnv: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(resources[0].Content); got != "<p>[]</p>" {
+		t.Errorf("missing.htl with AllowUndefinedEnv = %q, want <p>[]</p>", got)
+	}
+}
+
+func TestEmptyHTL(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	dir := writeFiles(t, map[string]string{
+		"empty.htl":   "",
+		"blank.htl":   " \n\t\n",
+		"comment.htl": "; nothing yet\n",
+		"page.htl":    "(p hi)",
+	})
+	for _, warn := range []bool{false, true} {
+		logs.Reset()
+		m, err := NewMux([]string{dir}, StaticOptions{WarnEmpty: warn})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{"/empty.html", "/blank.html", "/comment.html"} {
+			if w := get(m, p); w.Code != http.StatusOK || w.Body.Len() != 0 {
+				t.Errorf("GET %s = %d %q, want 200 and an empty body", p, w.Code, w.Body.String())
+			}
+		}
+		got := logs.String()
+		for _, name := range []string{"blank.htl", "comment.htl"} {
+			if strings.Contains(got, name) != warn {
+				t.Errorf("WarnEmpty %v: logs %q mention %s: %v", warn, got, name, !warn)
+			}
+		}
+		for _, name := range []string{"empty.htl", "page.htl"} {
+			if strings.Contains(got, name) {
+				t.Errorf("WarnEmpty %v: logs %q mention %s", warn, got, name)
+			}
+		}
+	}
+}
+
+func TestTransformerChain(t *testing.T) {
+	// A markdown transformer that only knows headings, and leaves the rest to
+	// the htl one.
+	sourceTypes[".fakemd"] = "text/x-fake-markdown"
+	typeTransformers["text/x-fake-markdown"] = func(r *Resource) ([]*Resource, error) {
+		title := strings.TrimSpace(strings.TrimPrefix(string(r.Content), "#"))
+		return []*Resource{{ContentType: htlContentType, Content: []byte("(h1 \"" + title + "\")")}}, nil
+	}
+	// Two transformers handing a resource back and forth.
+	sourceTypes[".loop"] = "text/x-a"
+	typeTransformers["text/x-a"] = func(r *Resource) ([]*Resource, error) {
+		return []*Resource{{ContentType: "text/x-b; charset=utf-8", Content: r.Content}}, nil
+	}
+	typeTransformers["text/x-b"] = func(r *Resource) ([]*Resource, error) {
+		return []*Resource{{ContentType: "text/x-a", Content: r.Content}}, nil
+	}
+	defer func() {
+		delete(sourceTypes, ".fakemd")
+		delete(sourceTypes, ".loop")
+		for _, t := range []string{"text/x-fake-markdown", "text/x-a", "text/x-b"} {
+			delete(typeTransformers, t)
+		}
+	}()
+
+	dir := writeFiles(t, map[string]string{"page.fakemd": "# Hello", "a.loop": "x"})
+	r, err := ResourceFromFile(filepath.Join(dir, "page.fakemd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.ContentType+" "+string(r.Content), "text/html; charset=utf-8 <h1>Hello</h1>"; got != want {
+		t.Errorf("ResourceFromFile(page.fakemd) = %q, want %q", got, want)
+	}
+	_, err = ResourceFromFile(filepath.Join(dir, "a.loop"))
+	if err == nil || !strings.Contains(err.Error(), "loop back to text/x-a") {
+		t.Errorf("ResourceFromFile(a.loop): err = %v, want a loop error", err)
+	}
+}
+
+func TestMuxIgnore(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"index.htl":        "(p hi)",
+		".DS_Store":        "x",
+		".git/config":      "x",
+		"a.tmp":            "x",
+		"sub/b.tmp":        "x",
+		"sub/c.txt":        "c",
+		"drafts/d.htl":     "(p d)",
+		"drafts/e.txt":     "e",
+		"other/drafts/f.x": "f",
+	})
+	cases := []struct {
+		opts StaticOptions
+		want []string
+	}{
+		{StaticOptions{},
+			[]string{"/a.tmp", "/drafts/d.html", "/drafts/e.txt", "/index.html", "/other/drafts/f.x", "/sub/b.tmp", "/sub/c.txt"}},
+		{StaticOptions{Ignore: []string{"*.tmp", "drafts/*.htl"}},
+			[]string{"/drafts/e.txt", "/index.html", "/other/drafts/f.x", "/sub/c.txt"}},
+		{StaticOptions{Ignore: []string{"drafts"}},
+			[]string{"/a.tmp", "/index.html", "/sub/b.tmp", "/sub/c.txt"}},
+		{StaticOptions{ServeDotfiles: true, Ignore: []string{"*.tmp", "*s"}},
+			[]string{"/.DS_Store", "/.git/config", "/index.html", "/sub/c.txt"}},
+	}
+	for _, c := range cases {
+		m, err := NewMux([]string{dir}, c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Paths(); strings.Join(got, " ") != strings.Join(c.want, " ") {
+			t.Errorf("%+v: Paths() = %q, want %q", c.opts, got, c.want)
+		}
+	}
+
+	m, err := NewMux([]string{dir}, StaticOptions{Listing: true, Ignore: []string{"*.tmp"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := get(m, "/").Body.String()
+	for _, hidden := range []string{".git", ".DS_Store", "a.tmp"} {
+		if strings.Contains(body, hidden) {
+			t.Errorf("listing of / shows ignored %s: %q", hidden, body)
+		}
+	}
+
+	if w := get(m, "/.git/"); w.Code != http.StatusNotFound {
+		t.Errorf("listing of /.git/ = %d %q, want 404", w.Code, w.Body.String())
+	}
+	m, err = NewMux([]string{dir}, StaticOptions{Listing: true, Ignore: []string{"drafts"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/drafts/", "/other/drafts/"} {
+		if w := get(m, p); w.Code != http.StatusNotFound {
+			t.Errorf("listing of ignored directory %s = %d %q, want 404", p, w.Code, w.Body.String())
+		}
+	}
+	if w := get(m, "/other/"); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "drafts") {
+		t.Errorf("listing of /other/ = %d %q, want it without drafts/", w.Code, w.Body.String())
+	}
+
+	if _, err := NewMux([]string{dir}, StaticOptions{Ignore: []string{"["}}); err == nil {
+		t.Errorf("NewMux with a malformed ignore pattern succeeded")
+	}
+}
+
+func TestHTLSourceNegotiation(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"page.htl": "(p hi)", "a.txt": "a"})
+	const html, source = "<p>hi</p>", "(p hi)"
+	cases := []struct {
+		accept, contentType, body string
+	}{
+		{"", "text/html; charset=utf-8", html},
+		{"*/*", "text/html; charset=utf-8", html},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "text/html; charset=utf-8", html},
+		{"text/plain", "text/plain; charset=utf-8", source},
+		{"application/htl", "application/htl", source},
+		{"text/html;q=0.5, application/htl", "application/htl", source},
+		{"text/plain;q=0.5, text/html", "text/html; charset=utf-8", html},
+		{"text/*", "text/html; charset=utf-8", html},
+		{"text/plain;q=0", "text/html; charset=utf-8", html},
+	}
+	for _, dev := range []bool{false, true} {
+		m, err := NewMux([]string{dir}, StaticOptions{Dev: dev})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range cases {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/page.html", nil)
+			r.Header.Set("Accept", c.accept)
+			m.ServeHTTP(w, r)
+			if got, want := w.Header().Get("Content-Type")+" "+w.Body.String(), c.contentType+" "+c.body; got != want {
+				t.Errorf("dev %v: GET /page.htl, Accept %q = %q, want %q", dev, c.accept, got, want)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("dev %v: Vary = %q, want Accept", dev, got)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/a.txt", nil)
+		r.Header.Set("Accept", "application/htl")
+		m.ServeHTTP(w, r)
+		if w.Body.String() != "a" || w.Header().Get("Vary") != "" {
+			t.Errorf("dev %v: GET /a.txt asking for htl = %q, Vary %q", dev, w.Body.String(), w.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestSitemap(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"index.htl":          "(p home)",
+		"about.htl":          "(p about)",
+		"docs/index.htl":     "(p docs)",
+		"docs/old.html":      "<p>old</p>",
+		"css/a.css":          "p {}",
+		"img/logo.png":       "",
+		"legal/terms v2.htl": "(p terms)",
+	})
+	m, err := NewMux([]string{dir}, StaticOptions{Index: "/index.html"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddSitemap("https://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := get(m, "/sitemap.xml")
+	if got := w.Header().Get("Content-Type"); !strings.Contains(got, "xml") {
+		t.Errorf("sitemap Content-Type = %q, want an xml type", got)
+	}
+	if !strings.HasPrefix(w.Body.String(), xml.Header) {
+		t.Errorf("sitemap lacks the xml declaration: %q", w.Body.String())
+	}
+	var set struct {
+		XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+		URLs    []struct {
+			Loc string `xml:"loc"`
+		} `xml:"url"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatalf("sitemap is not well-formed: %v\n%s", err, w.Body.String())
+	}
+	got := []string{}
+	for _, u := range set.URLs {
+		got = append(got, u.Loc)
+	}
+	want := []string{
+		"https://example.com/about.html",
+		"https://example.com/docs/",
+		"https://example.com/docs/old.html",
+		"https://example.com/",
+		"https://example.com/legal/terms%20v2.html",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("sitemap lists\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if got, want := get(m, "/robots.txt").Body.String(),
+		"User-agent: *\nAllow: /\nSitemap: https://example.com/sitemap.xml\n"; got != want {
+		t.Errorf("robots.txt = %q, want %q", got, want)
+	}
+
+	if err := m.AddSitemap("/relative"); err == nil {
+		t.Errorf("AddSitemap accepted a relative base URL")
+	}
+}
+
+func TestSitemapKeepsFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"index.htl":  "(p home)",
+		"robots.txt": "User-agent: *\nDisallow: /\n",
+	})
+	m, err := NewMux([]string{dir}, StaticOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddSitemap("https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(m, "/robots.txt").Body.String(); got != "User-agent: *\nDisallow: /\n" {
+		t.Errorf("robots.txt = %q, want the file's content", got)
+	}
+	if got := get(m, "/sitemap.xml").Body.String(); !strings.Contains(got, "<loc>https://example.com/index.html</loc>") {
+		t.Errorf("sitemap.xml = %q, want it to list /index.html", got)
+	}
+}
+
+func TestHostMux(t *testing.T) {
+	foo := writeFiles(t, map[string]string{"index.htl": "(p foo)"})
+	bar := writeFiles(t, map[string]string{"index.htl": "(p bar)", "bar.css": "b {}"})
+	hosts := map[string][]string{"foo.test": {foo}, "Bar.Test": {bar}}
+	h, err := NewHostMux(hosts, "", StaticOptions{Index: "/index.html"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withDefault, err := NewHostMux(hosts, "foo.test", StaticOptions{Index: "/index.html"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+	sd := httptest.NewServer(withDefault)
+	defer sd.Close()
+
+	cases := []struct {
+		server *httptest.Server
+		host   string
+		path   string
+		code   int
+		body   string
+	}{
+		{s, "foo.test", "/", 200, "<p>foo</p>"},
+		{s, "bar.test:8000", "/", 200, "<p>bar</p>"},
+		{s, "BAR.test.", "/bar.css", 200, "b {}"},
+		{s, "foo.test", "/bar.css", 404, ""},
+		{s, "other.test", "/", 404, ""},
+		{sd, "other.test", "/", 200, "<p>foo</p>"},
+		{sd, "bar.test", "/", 200, "<p>bar</p>"},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest("GET", c.server.URL+c.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = c.host
+		resp, err := c.server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != c.code || c.code == 200 && string(body) != c.body {
+			t.Errorf("GET %s with Host %s = %d, %q; want %d, %q", c.path, c.host, resp.StatusCode, body, c.code, c.body)
+		}
+	}
+
+	if _, err := NewHostMux(hosts, "missing.test", StaticOptions{}); err == nil {
+		t.Errorf("NewHostMux with an unknown default host succeeded, want an error")
+	}
+}
+
+func TestMuxHeaders(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"index.htl":      "(p hi)",
+		"app.wasm":       "\x00asm",
+		"dl/report.pdf":  "%PDF",
+		"report.pdf":     "%PDF",
+		"style/site.css": "p {}",
+	})
+	isolated := http.Header{
+		"Cross-Origin-Embedder-Policy": {"require-corp"},
+		"Cross-Origin-Opener-Policy":   {"same-origin"},
+	}
+	opts := StaticOptions{
+		Index: "/index.html",
+		Headers: map[string]http.Header{
+			"*.wasm":    isolated,
+			"*.html":    isolated,
+			"/dl/*.pdf": {"Content-Disposition": {"attachment"}},
+		},
+	}
+	m, err := NewMux([]string{dir}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path    string
+		headers http.Header
+	}{
+		{"/", isolated},
+		{"/app.wasm", isolated},
+		{"/dl/report.pdf", http.Header{"Content-Disposition": {"attachment"}}},
+		{"/report.pdf", nil},
+		{"/style/site.css", nil},
+		{"/missing.wasm", nil},
+	}
+	custom := []string{"Cross-Origin-Embedder-Policy", "Cross-Origin-Opener-Policy", "Content-Disposition"}
+	for _, c := range cases {
+		w := get(m, c.path)
+		for _, k := range custom {
+			if got, want := w.Header().Values(k), c.headers.Values(k); strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("GET %s: %s = %q, want %q", c.path, k, got, want)
+			}
+		}
+	}
+
+	opts.Headers = map[string]http.Header{"[": {"X": {"y"}}}
+	if _, err := NewMux([]string{dir}, opts); err == nil {
+		t.Errorf("NewMux with a malformed headers pattern succeeded, want an error")
+	}
+}
+
+func TestHandlersFromFSLayers(t *testing.T) {
+	base := fstest.MapFS{
+		"index.htl":    {Data: []byte("(p base)")},
+		"css/site.css": {Data: []byte("base {}")},
+		"css/more.css": {Data: []byte("more {}")},
+		".secret":      {Data: []byte("x")},
+	}
+	override := fstest.MapFS{
+		"css/site.css": {Data: []byte("override {}")},
+	}
+	for _, dev := range []bool{false, true} {
+		handlers, err := HandlersFromFSLayers([]fs.FS{base, override}, dev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{
+			"/index.html":   "<p>base</p>",
+			"/css/site.css": "override {}",
+			"/css/more.css": "more {}",
+		}
+		if len(handlers) != len(want) {
+			t.Errorf("dev %v: handlers for %d paths, want %d", dev, len(handlers), len(want))
+		}
+		for p, body := range want {
+			h, has := handlers[p]
+			if !has {
+				t.Errorf("dev %v: no handler for %s", dev, p)
+				continue
+			}
+			if got := get(h, p).Body.String(); got != body {
+				t.Errorf("dev %v: GET %s = %q, want %q", dev, p, got, body)
+			}
+		}
+	}
+
+	handlers, err := HandlersFromFSLayers([]fs.FS{base, override}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	override["css/site.css"] = &fstest.MapFile{Data: []byte("edited {}")}
+	if got := get(handlers["/css/site.css"], "/css/site.css").Body.String(); got != "edited {}" {
+		t.Errorf("dev GET after an edit = %q, want the edited file", got)
+	}
+}
+
+// manyFiles returns n files: pages, stylesheets and text files in nested
+// directories.
+func manyFiles(n int, body string) map[string]string {
+	files := map[string]string{}
+	for i := 0; i < n; i++ {
+		switch dir := fmt.Sprintf("d%d/", i%10); i % 3 {
+		case 0:
+			files[fmt.Sprintf("%spage%d.htl", dir, i)] = fmt.Sprintf("(p %s %d)", body, i)
+		case 1:
+			files[fmt.Sprintf("%sstyle%d.css", dir, i)] = fmt.Sprintf("p { content: %q }", body)
+		default:
+			files[fmt.Sprintf("%snote%d.txt", dir, i)] = body
+		}
+	}
+	return files
+}
+
+func TestHandlersFromDirsParallel(t *testing.T) {
+	defer func(n int) { loadWorkers = n }(loadWorkers)
+	loadWorkers = 8
+	files := manyFiles(300, "first")
+	first := writeFiles(t, files)
+	// The second directory overrides every other file of the first.
+	overrides := map[string]string{}
+	for name, content := range files {
+		if len(overrides) < len(files)/2 {
+			overrides[name] = strings.Replace(content, "first", "second", 1)
+		}
+	}
+	second := writeFiles(t, overrides)
+
+	m, err := NewMux([]string{first, second}, StaticOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		want := filepath.Join(first, filepath.FromSlash(name))
+		if _, has := overrides[name]; has {
+			want = filepath.Join(second, filepath.FromSlash(name))
+			content = overrides[name]
+		}
+		p := resourcePath("/"+name, outExts[filepath.Ext(name)])
+		if got := m.Source(p); got != want {
+			t.Errorf("Source(%q) = %q, want %q", p, got, want)
+		}
+		if strings.HasSuffix(name, ".htl") {
+			continue
+		}
+		if got := get(m, p).Body.String(); got != content {
+			t.Errorf("GET %s = %q, want %q", p, got, content)
+		}
+	}
+
+	// Of several broken files, the error is that of the first one walked.
+	broken := writeFiles(t, map[string]string{"d1/x.htl": "(p))", "d5/x.htl": "(p", "d9/x.htl": "(p"})
+	for n := 0; n < 5; n++ {
+		_, err := NewMux([]string{first, broken}, StaticOptions{})
+		if err == nil || !strings.Contains(err.Error(), "unexpected closing paren") {
+			t.Fatalf("NewMux with broken files: err = %v, want that of d1/x.htl", err)
+		}
+	}
+}
+
+func BenchmarkHandlersFromDirs(b *testing.B) {
+	dir := b.TempDir()
+	for name, content := range manyFiles(2000, "hello") {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := HandlersFromDirs([]string{dir}, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestServeListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeListener(ctx, &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "hello")
+		})}, l)
+	}()
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "hello" {
+		t.Errorf("GET = %q, %v; want hello", body, err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ServeListener after cancel = %v, want nil", err)
+		}
+	case <-time.After(shutdownTimeout + time.Second):
+		t.Fatal("ServeListener did not return after cancel")
+	}
+	if c, err := net.Dial("tcp", addr); err == nil {
+		c.Close()
+		t.Errorf("still listening on %s after shutdown", addr)
+	}
+
+	// An address in use fails at once, whatever the context.
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := Serve(context.Background(), l.Addr().String(), http.NotFoundHandler()); err == nil {
+		t.Errorf("Serve on an address in use succeeded, want an error")
+	}
+}
+
+func TestServeListenerBackground(t *testing.T) {
+	// watcher stands for a goroutine running alongside the server, and
+	// reports on stopped once its context is done.
+	watcher := func(started, stopped chan<- bool) func(context.Context) {
+		return func(ctx context.Context) {
+			started <- true
+			<-ctx.Done()
+			stopped <- true
+		}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	started, stopped := make(chan bool, 2), make(chan bool, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeListener(ctx, &http.Server{Handler: http.NotFoundHandler()}, l,
+			watcher(started, stopped), watcher(started, stopped))
+	}()
+	for i := 0; i < 2; i++ {
+		<-started
+	}
+	select {
+	case <-stopped:
+		t.Fatal("background func stopped while serving")
+	default:
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ServeListener after cancel = %v, want nil", err)
+		}
+	case <-time.After(shutdownTimeout + time.Second):
+		t.Fatal("ServeListener did not return after cancel")
+	}
+	// Both have stopped by the time ServeListener returns.
+	if len(stopped) != 2 {
+		t.Errorf("%d background funcs stopped by the time ServeListener returned, want 2", len(stopped))
+	}
+	if c, err := net.Dial("tcp", addr); err == nil {
+		c.Close()
+		t.Errorf("still listening on %s after shutdown", addr)
+	}
+
+	// A server that fails stops them too, though ctx is not done.
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	started, stopped = make(chan bool, 1), make(chan bool, 1)
+	err = ServeListener(context.Background(), &http.Server{}, l, watcher(started, stopped))
+	if err == nil {
+		t.Errorf("ServeListener on a closed listener succeeded, want an error")
+	}
+	if len(stopped) != 1 {
+		t.Errorf("background func still running after the server failed")
+	}
+}
+
+func TestRawHTL(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"page.htl": "(p \"a & b\") ; note\n", "a.css": "p {}"})
+	cases := []struct {
+		raw               bool
+		body, contentType string
+	}{
+		{false, "<p>a &amp; b</p>", "text/html; charset=utf-8"},
+		{true, "(p \"a & b\") ; note\n", "text/plain; charset=utf-8"},
+	}
+	for _, c := range cases {
+		for _, dev := range []bool{false, true} {
+			m, err := NewMux([]string{dir}, StaticOptions{RawHTL: c.raw, Dev: dev})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := get(m, "/page.html")
+			if got := w.Body.String(); got != c.body {
+				t.Errorf("RawHTL %v, dev %v: GET /page.htl = %q, want %q", c.raw, dev, got, c.body)
+			}
+			if got := w.Header().Get("Content-Type"); got != c.contentType {
+				t.Errorf("RawHTL %v, dev %v: Content-Type = %q, want %q", c.raw, dev, got, c.contentType)
+			}
+			if got := get(m, "/a.css").Body.String(); got != "p {}" {
+				t.Errorf("RawHTL %v, dev %v: GET /a.css = %q, want it untouched", c.raw, dev, got)
+			}
+		}
+	}
+}
+
+func TestMuxEscapedPaths(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"my file.html":      "spaces",
+		"100%.txt":          "percent",
+		"a+b.txt":           "plus",
+		"what?.txt":         "question",
+		"hash#1.txt":        "hash",
+		"café/naïve 1.htl":  "(p unicode)",
+		"my dir/index.htl":  "(p dir)",
+		"my dir/a&b=c.html": "amp",
+	})
+	m, err := NewMux([]string{dir}, StaticOptions{
+		Index: "/index.html", Listing: true, RedirectToCanonical: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for target, want := range map[string]string{
+		"/my%20file.html":                "spaces",
+		"/100%25.txt":                    "percent",
+		"/a+b.txt":                       "plus",
+		"/a%2Bb.txt":                     "plus",
+		"/what%3F.txt":                   "question",
+		"/hash%231.txt":                  "hash",
+		"/caf%C3%A9/na%C3%AFve%201.html": "<p>unicode</p>",
+		"/café/naïve%201.html":           "<p>unicode</p>",
+		"/my%20dir/":                     "<p>dir</p>",
+		"/my%20dir/a&b=c.html":           "amp",
+		"/my%20dir/a%26b%3Dc.html":       "amp",
+	} {
+		if got := get(m, target).Body.String(); got != want {
+			t.Errorf("GET %s = %q, want %q", target, got, want)
+		}
+	}
+	if got := m.Source("/my file.html"); got != filepath.Join(dir, "my file.html") {
+		t.Errorf("Source(/my file.html) = %q, want the file", got)
+	}
+
+	w := get(m, "/my%20dir")
+	if got, want := w.Header().Get("Location"), "/my%20dir/"; w.Code != http.StatusMovedPermanently || got != want {
+		t.Errorf("GET /my%%20dir: %d to %q, want 301 to %q", w.Code, got, want)
+	}
+	listing := get(m, "/café/").Body.String()
+	if want := `href="/caf%C3%A9/na%C3%AFve%201.html"`; !strings.Contains(listing, want) {
+		t.Errorf("listing of /café/ = %q, want it to hold %s", listing, want)
+	}
+	listing = get(m, "/").Body.String()
+	for _, want := range []string{`href="/100%25.txt"`, `href="/what%3F.txt"`, `href="/hash%231.txt"`, `href="/my%20dir/"`} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("listing of / = %q, want it to hold %s", listing, want)
+		}
+	}
+}
+
+func TestMaxInMemoryBytes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"small.txt": "12345",
+		"limit.txt": "1234567890",
+		"huge.log":  strings.Repeat("x", 11),
+	})
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	for _, dev := range []bool{false, true} {
+		opts := StaticOptions{MaxInMemoryBytes: 10, Dev: dev}
+		m, err := NewMux([]string{dir}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.Join(m.Paths(), " "), "/limit.txt /small.txt"; got != want {
+			t.Errorf("dev %v: Paths() = %q, want %q", dev, got, want)
+		}
+		if got := get(m, "/huge.log").Code; got != http.StatusNotFound {
+			t.Errorf("dev %v: GET /huge.log: status %d, want 404", dev, got)
+		}
+		if got := get(m, "/limit.txt").Body.String(); got != "1234567890" {
+			t.Errorf("dev %v: GET /limit.txt = %q, want the file", dev, got)
+		}
+	}
+	if !strings.Contains(logged.String(), "huge.log: 11 bytes is over the limit of 10") {
+		t.Errorf("log = %q, want a warning about huge.log", logged.String())
+	}
+
+	// In dev mode, a file that grows over the limit fails to load.
+	m, err := NewMux([]string{dir}, StaticOptions{MaxInMemoryBytes: 10, Dev: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "small.txt"), []byte(strings.Repeat("y", 20)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(m, "/small.txt").Code; got != http.StatusInternalServerError {
+		t.Errorf("dev GET of a file grown over the limit: status %d, want 500", got)
+	}
+	if _, err := NewMux([]string{dir}, StaticOptions{}); err != nil {
+		t.Errorf("NewMux with no limit: %v", err)
+	}
+}
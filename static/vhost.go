@@ -0,0 +1,62 @@
+package static
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// HostMux serves each request with the handler of the host named by its Host
+// header, such as the Mux of one of several sites, and requests for any other
+// host with Default, or 404 Not Found if Default is nil.
+type HostMux struct {
+	// Hosts maps host names, lowercase and without a port, to their handlers.
+	Hosts map[string]http.Handler
+
+	Default http.Handler
+}
+
+// NewHostMux returns a HostMux serving, for each host name in hosts, a Mux of
+// its directories, as NewMux would with opts.  If defaultHost is not empty,
+// other hosts are served as that one.
+func NewHostMux(hosts map[string][]string, defaultHost string, opts StaticOptions) (*HostMux, error) {
+	h := &HostMux{Hosts: map[string]http.Handler{}}
+	for host, dirs := range hosts {
+		m, err := NewMux(dirs, opts)
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %v", host, err)
+		}
+		h.Hosts[hostName(host)] = m
+	}
+	if defaultHost != "" {
+		m, has := h.Hosts[hostName(defaultHost)]
+		if !has {
+			return nil, fmt.Errorf("default host %s is not one of the hosts", defaultHost)
+		}
+		h.Default = m
+	}
+	return h, nil
+}
+
+func (h *HostMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, has := h.Hosts[hostName(r.Host)]; has {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	if h.Default != nil {
+		h.Default.ServeHTTP(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// hostName returns the host name of host, a Host header or a name given by
+// the caller, without the port, lowercased and without a trailing dot, so
+// Example.COM.:8000 and example.com are the same.
+func hostName(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}